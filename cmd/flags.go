@@ -4,7 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"reflect"
+	"slices"
+	"strings"
 
 	"github.com/caarlos0/env/v10"
 	"github.com/hermo/npmi-go/pkg/npmi"
@@ -17,28 +18,111 @@ npmi-go installs NPM packages from a cache to speed up repeating installations.
 Supported caches:
 -  local          Data is cached locally in a directory.
 -  minio          Data is cached to a (shared) Minio instance.
+-  http           Data is cached to a (shared) server run using npmi-go serve.
+-  exec           Data is cached using a plugin executable, see README.md.
 
-When using both caches, the local one is accessed first.
+Caches are accessed in the order local, minio, http, exec.
 
 USAGE:
- npmi-go [OPTIONS]
+ npmi-go [COMMAND] [OPTIONS]
+
+COMMANDS:
+  install  Install packages from cache or using npm (default)
+  verify   Compare node_modules against the cached archive of the project
+           and exit with a non-zero exit code if they differ. Options:
+           -archive string  Compare against the given archive file instead
+  export   Write the cache entry of KEY to a portable archive file. Options:
+           -o string        File to write the archive to (required)
+           -current         Export the entry of the current project instead
+  import   Validate a portable archive file and store it in all enabled
+           caches. Options:
+           -key string      Store under the given key instead of the key
+                            recorded in the archive manifest
+  sync     Copy entries missing from one cache to another. Options:
+           -from string     Cache to copy entries from: local or minio
+           -to string       Cache to copy entries to: local, minio, http or exec
+           -from-config string
+                            Configuration file of the source cache
+           -to-config string
+                            Configuration file of the target cache
+           -prefix string   Copy only entries whose key starts with the prefix
+           -max-age duration
+                            Copy only entries stored within the duration
+           -concurrency int Number of entries copied at the same time (default 4)
+           -dry-run         Only report the entries which would be copied
+  warm     Cache the packages of the project for each Node.js given as a
+           path to a node binary or as a version installed using nvm, volta
+           or fnm, e.g. npmi-go warm 18 20 /opt/node22/bin/node
+  serve    Serve a directory-backed cache over HTTP for clients using the
+           http cache until interrupted. Options:
+           -listen string   Address to listen on (default ":8080")
+           -dir string      Directory dedicated to storing entries in
+                            (required)
+           -token string    Token required from clients (default:
+                            NPMI_SERVE_TOKEN)
+           -allow-anonymous Allow access without a token
+           -max-entry-size value
+                            Maximum size of an entry, e.g. 512M
+           -max-size value  Maximum total size of entries, e.g. 50G, evicting
+                            the least recently used ones
+
+CONFIGURATION FILES:
+Options may also be set in a YAML or JSON configuration file, see README.md.
+The first of .npmirc.yaml, .npmirc.yml and .npmirc.json found is used.
+
+  $HOME/.npmirc.yaml  User configuration
+  ./.npmirc.yaml      Project configuration, overrides the user configuration.
+                      Replaced by the file given using -config or NPMI_CONFIG.
+
+Options are applied in order of precedence:
+flags > env variables > project config > user config > defaults
 
 ENVIRONMENT VARIABLES:
 Use the following env variables to set default options.
 
-  NPMI_LOGLEVEL  Log level. One of info|debug|trace (Default: "info")
-  NPMI_JSON      Use JSON for log output (Default: false)
-  NPMI_VERBOSE   Verbose output. DEPRECATED
-                 Please use NPMI_LOGLEVEL with 'debug' or 'trace'
-  NPMI_FORCE     Force (re)installation of deps
-  NPMI_PRECACHE  Pre-cache command
-  NPMI_TEMP_DIR  Use specified temp directory when creating archives (Default: system temp)
+  NPMI_CONFIG        Project configuration file to use instead of ./.npmirc.yaml
+  NPMI_LOGLEVEL      Log level. One of info|debug|trace (Default: "info")
+  NPMI_JSON          Use JSON for log output (Default: false)
+  NPMI_VERBOSE       Verbose output. DEPRECATED
+                     Please use NPMI_LOGLEVEL with 'debug' or 'trace'
+  NPMI_FORCE         Force (re)installation of deps
+  NPMI_PRECACHE      Pre-cache command
+  NPMI_POSTRESTORE   Post-restore command, run after installing from cache
+  NPMI_TEMP_DIR      Use specified temp directory when creating archives (Default: system temp)
+  NPMI_LOCK_TIMEOUT  Maximum time to wait for another run using the same project (Default: no limit)
+  NPMI_REPORT        Write a JSON report of the run to the given file
+
+Installation:
+  NPMI_INSTALL_ARGS     Space-separated extra arguments passed to npm ci, e.g. "--omit=dev --ignore-scripts"
+  NPMI_INSTALL_COMMAND  Shell command run instead of npm ci
+  NPMI_MODE             Install mode. One of auto|prod|dev (Default: "auto")
+  NPMI_DERIVE_PROD      Derive the production tree from a cached development tree (Default: false)
+  NPMI_STREAM           Log the output of npm and precache commands live (Default: false)
+  NPMI_STREAM_TAIL      Number of output lines kept for error messages when streaming (Default: 50)
+
+Cache key:
+  NPMI_KEY_FILES        Comma-separated file globs whose contents are included in the cache key
+  NPMI_KEY_ENV          Comma-separated names of env variables whose values are included in the cache key
+  NPMI_PLATFORM_DISTRO  Include the OS distribution from /etc/os-release in the platform (Default: false)
+  NPMI_NAMESPACE        Namespace prefixed to cache keys, e.g. "team-a/myapp"
+  NPMI_PROJECT          Project identifier prefixed to cache keys after the namespace. "auto" derives it
+                        from the name in package.json or the git remote origin
+
+Metrics:
+  NPMI_METRICS_FILE      Write Prometheus metrics of the run to a file, e.g. for the node_exporter textfile collector
+  NPMI_METRICS_PUSH_URL  Push Prometheus metrics of the run to a Pushgateway at this URL
+  NPMI_METRICS_JOB       Job name used when pushing metrics (Default: "npmi-go")
 
 Tar file security hardening:
   NPMI_TAR_ABSOLUTE_PATHS     Allow absolute paths in tar archives (Default: true)
   NPMI_TAR_DOUBLE_DOT_PATHS   Allow double dot paths in tar archives (Default: true)
   NPMI_TAR_LINKS_OUTSIDE_CWD  Allow links outside of the current working directory (Default: true)
 
+Tracing:
+  OTEL_EXPORTER_OTLP_ENDPOINT  Export OpenTelemetry spans of the run via OTLP/HTTP to this endpoint
+  TRACEPARENT                  W3C trace context of a parent span, e.g. a CI pipeline step
+  Other standard OTEL_* variables such as OTEL_EXPORTER_OTLP_HEADERS are supported as well.
+
 Local cache:
   NPMI_LOCAL      Use local cache
   NPMI_LOCAL_DIR  Local cache directory (Default: system temp)
@@ -51,53 +135,49 @@ Minio cache:
   NPMI_MINIO_BUCKET             Minio bucket name
   NPMI_MINIO_TLS                Use TLS when connection to minio
   NPMI_MINIO_TLS_INSECURE       Disable TLS certificate checks
+  NPMI_MINIO_TIMEOUT            Timeout of a single Minio operation other than an upload, e.g. "30s". 0 disables it (Default: 5m)
+  NPMI_MINIO_DEADLINE           Overall deadline of a Minio operation including retries (Default: none)
+  NPMI_MINIO_RETRIES            Number of retries for failed Minio operations (Default: 2)
+  NPMI_MINIO_RETRY_BACKOFF      Initial delay between retries, doubled after each retry (Default: 500ms)
+  NPMI_MINIO_LOCK_TTL           Wait for other clients building the same key, treating locks
+                                older than this as abandoned, e.g. "15m" (Default: disabled)
+
+HTTP cache:
+  NPMI_HTTP        Use a cache served by npmi-go serve
+  NPMI_HTTP_URL    URL of the cache server, e.g. "http://cache.example.com:8080"
+  NPMI_HTTP_TOKEN  Token used to authenticate to the cache server
+
+Plugin cache:
+  NPMI_EXEC          Use a cache implemented by a plugin executable
+  NPMI_EXEC_COMMAND  Plugin executable, e.g. "npmi-cache-artifactory"
+  NPMI_EXEC_ARGS     Space-separated arguments passed to the plugin before the operation
 
 OPTIONS:
 `
 )
 
-// ParseFlags parses command line flags
-func ParseFlags() (*npmi.Options, error) {
-	options := &npmi.Options{
-		LogLevel: npmi.Info,
-		Force:    false,
-
-		UseLocalCache:      true,
-		UseMinioCache:      false,
-		TarDoubleDotPaths:  true,
-		TarAbsolutePaths:   true,
-		TarLinksOutsideCwd: true,
-		PrecacheCommand:    "",
-		TempDir:            os.TempDir(),
-	}
-	localCache := &npmi.LocalCacheOptions{
-		Dir: os.TempDir(),
-	}
-	minioCache := &npmi.MinioCacheOptions{
-		Endpoint:        "",
-		AccessKeyID:     "",
-		SecretAccessKey: "",
-		Bucket:          "",
-		UseTLS:          true,
-		InsecureTLS:     false,
-	}
-	options.LocalCache = localCache
-	options.MinioCache = minioCache
-
-	logLevelParser := func(v string) (interface{}, error) {
-		level := npmi.LogLevelFromString(v)
-		if level == npmi.NoLevel {
-			return nil, fmt.Errorf("invalid loglevel '%s'", v)
-		}
-		return level, nil
+// ParseFlags parses command line flags given after the command
+func ParseFlags(args []string) (*npmi.Options, error) {
+	options := npmi.DefaultOptions()
+	localCache := options.LocalCache
+	minioCache := options.MinioCache
+	httpCache := options.HTTPCache
+	execCache := options.ExecCache
+
+	configFile := findConfigFlag(args)
+	if configFile == "" {
+		configFile = os.Getenv("NPMI_CONFIG")
+	}
+	if err := loadConfigFiles(options, configFile); err != nil {
+		return nil, fmt.Errorf("could not load config file: %v", err)
 	}
 
-	if err := env.ParseWithOptions(options, env.Options{FuncMap: map[reflect.Type]env.ParserFunc{
-		reflect.TypeOf(npmi.LogLevel(0)): logLevelParser,
-	}}); err != nil {
+	if err := env.Parse(options); err != nil {
 		return nil, fmt.Errorf("could not parse env options: %+v", err)
 	}
 
+	options.InstallArgs = slices.DeleteFunc(options.InstallArgs, func(arg string) bool { return arg == "" })
+
 	if err := env.Parse(localCache); err != nil {
 		return nil, fmt.Errorf("could not parse env options: %+v", err)
 	}
@@ -106,8 +186,19 @@ func ParseFlags() (*npmi.Options, error) {
 		return nil, fmt.Errorf("could not parse env options: %+v", err)
 	}
 
+	if err := env.Parse(httpCache); err != nil {
+		return nil, fmt.Errorf("could not parse env options: %+v", err)
+	}
+
+	if err := env.Parse(execCache); err != nil {
+		return nil, fmt.Errorf("could not parse env options: %+v", err)
+	}
+	execCache.Args = slices.DeleteFunc(execCache.Args, func(arg string) bool { return arg == "" })
+
+	flag.String("config", configFile, "Project configuration file to use instead of ./.npmirc.yaml")
 	flag.BoolVar(&options.Verbose, "verbose", options.Verbose, "Verbose output, DEPRECATED\nPlease use -loglevel with 'debug' or 'trace'")
 	flag.BoolVar(&options.Force, "force", options.Force, "Force (re)installation of NPM deps and update cache(s)")
+	flag.DurationVar(&options.LockTimeout, "lock-timeout", options.LockTimeout, "Maximum time to wait for another run using the same project, 0 for no limit")
 	flag.BoolVar(&options.UseLocalCache, "local", options.UseLocalCache, "Use local cache")
 	flag.String("loglevel", "info", "Log level. One of info|debug|trace")
 	flag.BoolVar(&options.Json, "json", options.Json, "Use JSON output")
@@ -119,7 +210,34 @@ func ParseFlags() (*npmi.Options, error) {
 	flag.StringVar(&minioCache.Bucket, "minio-bucket", minioCache.Bucket, "Minio Bucket")
 	flag.BoolVar(&minioCache.UseTLS, "minio-tls", minioCache.UseTLS, "Use TLS to access Minio cache")
 	flag.BoolVar(&minioCache.InsecureTLS, "minio-tls-insecure", minioCache.InsecureTLS, "Disable TLS certificate checks")
+	flag.DurationVar(&minioCache.Timeout, "minio-timeout", minioCache.Timeout, "Timeout of a single Minio operation other than an upload, 0 disables it")
+	flag.DurationVar(&minioCache.Deadline, "minio-deadline", minioCache.Deadline, "Overall deadline of a Minio operation including retries, 0 for none")
+	flag.IntVar(&minioCache.Retries, "minio-retries", minioCache.Retries, "Number of retries for failed Minio operations")
+	flag.DurationVar(&minioCache.RetryBackoff, "minio-retry-backoff", minioCache.RetryBackoff, "Initial delay between retries of failed Minio operations")
+	flag.DurationVar(&minioCache.LockTTL, "minio-lock-ttl", minioCache.LockTTL, "Wait for other clients building the same key, treating locks older than this as abandoned. 0 disables")
+	flag.BoolVar(&options.UseHTTPCache, "http", options.UseHTTPCache, "Use a cache served by npmi-go serve")
+	flag.StringVar(&httpCache.URL, "http-url", httpCache.URL, "URL of the cache server")
+	flag.StringVar(&httpCache.Token, "http-token", httpCache.Token, "Token used to authenticate to the cache server")
+	flag.BoolVar(&options.UseExecCache, "exec", options.UseExecCache, "Use a cache implemented by a plugin executable")
+	flag.StringVar(&execCache.Command, "exec-command", execCache.Command, "Plugin executable implementing the cache")
+	flag.Var((*argList)(&execCache.Args), "exec-args", "Arguments passed to the plugin before the operation, e.g. \"--repo npmi\"")
+	flag.Var((*stringList)(&options.KeyFiles), "key-files", "Comma-separated file globs whose contents are included in the cache key, e.g. \".npmrc,patches/*.patch\"")
+	flag.Var((*stringList)(&options.KeyEnv), "key-env", "Comma-separated names of env variables whose values are included in the cache key")
+	flag.StringVar(&options.Namespace, "namespace", options.Namespace, "Namespace prefixed to cache keys, e.g. team-a/myapp")
+	flag.StringVar(&options.Project, "project", options.Project, "Project identifier prefixed to cache keys after the namespace\n\"auto\" derives it from the name in package.json or the git remote origin")
+	flag.BoolVar(&options.PlatformDistro, "platform-distro", options.PlatformDistro, "Include the OS distribution from /etc/os-release in the platform")
+	flag.StringVar(&options.Mode, "mode", options.Mode, "Install mode. One of auto|prod|dev\nauto uses prod if NODE_ENV=production and leaves omitting dev deps to npm")
+	flag.BoolVar(&options.DeriveProd, "derive-prod", options.DeriveProd, "Derive the production tree from a cached development tree using npm prune")
+	flag.Var((*argList)(&options.InstallArgs), "install-args", "Extra arguments passed to npm ci, e.g. \"--omit=dev --ignore-scripts\"")
+	flag.StringVar(&options.InstallCommand, "install-command", options.InstallCommand, "Run the following shell command instead of npm ci")
+	flag.StringVar(&options.PostRestoreCommand, "postrestore", options.PostRestoreCommand, "Run the following shell command after installing packages from cache")
+	flag.BoolVar(&options.Stream, "stream", options.Stream, "Log the output of npm and precache commands live instead of after they exit")
+	flag.IntVar(&options.StreamTail, "stream-tail", options.StreamTail, "Number of output lines kept for error messages when streaming")
 	flag.StringVar(&options.PrecacheCommand, "precache", options.PrecacheCommand, "Run the following shell command before caching packages")
+	flag.StringVar(&options.MetricsFile, "metrics-file", options.MetricsFile, "Write Prometheus metrics of the run to the given file")
+	flag.StringVar(&options.MetricsPushURL, "metrics-push-url", options.MetricsPushURL, "Push Prometheus metrics of the run to a Pushgateway at this URL")
+	flag.StringVar(&options.MetricsJob, "metrics-job", options.MetricsJob, "Job name used when pushing metrics")
+	flag.StringVar(&options.ReportFile, "report", options.ReportFile, "Write a JSON report of the run to the given file")
 	flag.StringVar(&options.TempDir, "temp-dir", options.TempDir, "Temporary directory for archive creation")
 	flag.BoolVar(&options.TarDoubleDotPaths, "tar-double-dot-paths", options.TarDoubleDotPaths, "Allow double dot paths in tar archives")
 	flag.BoolVar(&options.TarAbsolutePaths, "tar-absolute-paths", options.TarAbsolutePaths, "Allow absolute paths in tar archives")
@@ -130,7 +248,9 @@ func ParseFlags() (*npmi.Options, error) {
 		flag.PrintDefaults()
 	}
 
-	flag.Parse()
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return nil, err
+	}
 	if err := parseLogLevel(options); err != nil {
 		return nil, err
 	}
@@ -138,6 +258,90 @@ func ParseFlags() (*npmi.Options, error) {
 	return options, nil
 }
 
+// loadConfigFiles loads the user configuration file and the project configuration
+// file on top of it. If configFile is set, it is used as the project configuration.
+func loadConfigFiles(options *npmi.Options, configFile string) error {
+	if home, err := os.UserHomeDir(); err == nil {
+		userConfigFile, err := npmi.FindConfigFile(home)
+		if err != nil {
+			return err
+		}
+		if userConfigFile != "" {
+			if err := npmi.LoadConfigFile(userConfigFile, options); err != nil {
+				return err
+			}
+		}
+	}
+
+	if configFile == "" {
+		var err error
+		if configFile, err = npmi.FindConfigFile("."); err != nil {
+			return err
+		}
+	}
+	if configFile != "" {
+		return npmi.LoadConfigFile(configFile, options)
+	}
+	return nil
+}
+
+// findConfigFlag finds the value of the -config flag before the flags are parsed,
+// as configuration files must be loaded before env variables and flags are applied
+func findConfigFlag(args []string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// stringList is a flag value holding a comma-separated list of strings
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// argList is a flag value holding a space-separated list of arguments
+type argList []string
+
+func (l *argList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, " ")
+}
+
+func (l *argList) Set(value string) error {
+	*l = strings.Fields(value)
+	return nil
+}
+
 func parseLogLevel(options *npmi.Options) (err error) {
 	flag.Visit(func(f *flag.Flag) {
 		if f.Name == "loglevel" {
@@ -1,14 +1,82 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hermo/npmi-go/pkg/npmi"
 )
 
+// tracingShutdownTimeout limits the time spent flushing spans before exiting
+const tracingShutdownTimeout = 5 * time.Second
+
+// Commands
+const (
+	installCommand = "install"
+	verifyCommand  = "verify"
+	exportCommand  = "export"
+	importCommand  = "import"
+	syncCommand    = "sync"
+	warmCommand    = "warm"
+	serveCommand   = "serve"
+)
+
+// commandOptions holds the options of commands other than install
+type commandOptions struct {
+	archiveFile string
+	outputFile  string
+	cacheKey    string
+	currentKey  bool
+	from        string
+	to          string
+	fromConfig  string
+	toConfig    string
+	sync        npmi.SyncOptions
+	serve       serveOptions
+}
+
+// registerFlags registers the flags specific to a command
+func (o *commandOptions) registerFlags(command string) {
+	switch command {
+	case verifyCommand:
+		flag.StringVar(&o.archiveFile, "archive", "", "Compare against the given archive file instead of the cached one")
+	case exportCommand:
+		flag.StringVar(&o.outputFile, "o", "", "File to export the cache entry to")
+		flag.BoolVar(&o.currentKey, "current", false, "Export the cache entry of the current project")
+	case importCommand:
+		flag.StringVar(&o.cacheKey, "key", "", "Import using the given cache key instead of the one in the archive manifest")
+	case syncCommand:
+		flag.StringVar(&o.from, "from", "", "Cache to copy entries from: local or minio")
+		flag.StringVar(&o.to, "to", "", "Cache to copy entries to: local, minio, http or exec")
+		flag.StringVar(&o.fromConfig, "from-config", "", "Configuration file of the cache to copy entries from")
+		flag.StringVar(&o.toConfig, "to-config", "", "Configuration file of the cache to copy entries to")
+		flag.StringVar(&o.sync.Prefix, "prefix", "", "Copy only entries whose key starts with the prefix, e.g. a platform")
+		flag.DurationVar(&o.sync.MaxAge, "max-age", 0, "Copy only entries stored within the duration")
+		flag.IntVar(&o.sync.Concurrency, "concurrency", 4, "Number of entries copied at the same time")
+		flag.BoolVar(&o.sync.DryRun, "dry-run", false, "Only report the entries which would be copied")
+	case serveCommand:
+		flag.StringVar(&o.serve.listen, "listen", ":8080", "Address to listen on")
+		flag.StringVar(&o.serve.dir, "dir", "", "Directory dedicated to storing entries in (required)")
+		flag.StringVar(&o.serve.token, "token", "", "Token required from clients (default: NPMI_SERVE_TOKEN)")
+		flag.BoolVar(&o.serve.allowAnonymous, "allow-anonymous", false, "Allow access without a token")
+		flag.Var(&o.serve.maxEntrySize, "max-entry-size", "Maximum size of an entry, e.g. 512M, 0 for no limit")
+		flag.Var(&o.serve.maxSize, "max-size", "Maximum total size of entries, e.g. 50G, evicting the least recently used ones, 0 for no limit")
+	}
+}
+
 func Execute() {
-	options, err := ParseFlags()
+	command, args := parseCommand(os.Args[1:])
+
+	commandOptions := &commandOptions{}
+	commandOptions.registerFlags(command)
+
+	options, err := ParseFlags(args)
 	if err != nil {
 		// Create a default logger for handling early errors.
 		log := hclog.New(&hclog.LoggerOptions{
@@ -26,15 +94,76 @@ func Execute() {
 		Color:      hclog.AutoColor,
 	})
 
-	m, err := npmi.New(options, log)
+	// Cancel everything in progress when interrupted, e.g. by a CI job being cancelled.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ctx, shutdownTracing, err := npmi.InitTracing(ctx)
+	if err != nil {
+		log.Warn("Tracing initialization failed, continuing without tracing", "error", err)
+	}
+
+	var code int
+	switch command {
+	case verifyCommand:
+		code = verify(ctx, options, commandOptions.archiveFile, log)
+	case exportCommand:
+		code = exportEntry(ctx, options, commandOptions, flag.Args(), log)
+	case importCommand:
+		code = importEntry(ctx, options, commandOptions, flag.Args(), log)
+	case syncCommand:
+		code = syncCaches(ctx, options, commandOptions, log)
+	case warmCommand:
+		code = warm(ctx, options, flag.Args(), log)
+	case serveCommand:
+		code = serve(ctx, &commandOptions.serve, log)
+	default:
+		code = run(ctx, options, log)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Warn("Could not flush traces", "error", err)
+	}
+
+	if code != 0 {
+		os.Exit(code)
+	}
+}
+
+// run performs the installation and returns the exit code of the process
+func run(ctx context.Context, options *npmi.Options, log hclog.Logger) int {
+	installer, err := npmi.NewInstaller(ctx, npmi.WithCLIOptions(options), npmi.WithLogger(log))
 	if err != nil {
 		log.Error("Initialization failed", "error", err)
-		os.Exit(1)
+		return exitCode(ctx)
 	}
 
-	err = m.Run()
+	_, err = installer.Run(ctx)
 	if err != nil {
 		log.Error("Installation failed", "error", err)
-		os.Exit(1)
+		return exitCode(ctx)
+	}
+	return 0
+}
+
+// parseCommand returns the command given as the first argument and the remaining arguments.
+// Without a command, packages are installed.
+func parseCommand(args []string) (command string, rest []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case installCommand, verifyCommand, exportCommand, importCommand, syncCommand, warmCommand, serveCommand:
+			return args[0], args[1:]
+		}
+	}
+	return installCommand, args
+}
+
+// exitCode returns the exit code for a failure, using the conventional code 130 when interrupted
+func exitCode(ctx context.Context) int {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return 130
 	}
+	return 1
 }
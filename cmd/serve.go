@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hermo/npmi-go/pkg/cache"
+)
+
+// serverShutdownTimeout limits the time given to requests in progress when the server is stopped
+const serverShutdownTimeout = 30 * time.Second
+
+// serveOptions holds the options of the serve command
+type serveOptions struct {
+	listen         string
+	dir            string
+	token          string
+	allowAnonymous bool
+	maxEntrySize   byteSize
+	maxSize        byteSize
+}
+
+// serve serves a directory-backed cache over HTTP until interrupted and returns the exit code of the process
+func serve(ctx context.Context, serveOptions *serveOptions, log hclog.Logger) int {
+	// Entries are evicted from the directory, so it must be dedicated to the server
+	dir := serveOptions.dir
+	if dir == "" {
+		log.Error("No directory given, use -dir to give a directory dedicated to the server")
+		return 1
+	}
+	token := serveOptions.token
+	if token == "" {
+		token = os.Getenv("NPMI_SERVE_TOKEN")
+	}
+	if token == "" {
+		if !serveOptions.allowAnonymous {
+			log.Error("No token given, use -token or NPMI_SERVE_TOKEN, or -allow-anonymous to allow access without a token")
+			return 1
+		}
+		log.Warn("No token given, allowing anonymous access")
+	}
+
+	server, err := cache.NewServer(dir, cache.ServerOptions{
+		Token:          token,
+		AllowAnonymous: serveOptions.allowAnonymous,
+		MaxEntrySize:   int64(serveOptions.maxEntrySize),
+		MaxSize:        int64(serveOptions.maxSize),
+	}, log.Named("serve"))
+	if err != nil {
+		log.Error("Initialization failed", "error", err)
+		return 1
+	}
+
+	httpServer := &http.Server{
+		Addr:              serveOptions.listen,
+		Handler:           server,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	stats := server.Stats()
+	log.Info("Serving cache", "listen", serveOptions.listen, "dir", dir, "entries", stats.Entries, "size", stats.Size)
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Error("Serving failed", "error", err)
+		return 1
+	}
+	log.Info("Server stopped")
+	return 0
+}
+
+// byteSize is a flag value holding a number of bytes, given with an optional
+// binary unit suffix such as 512M or 10G
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	value = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+	multiplier := int64(1)
+	for i, unit := range []string{"K", "M", "G", "T"} {
+		if number, ok := strings.CutSuffix(value, unit); ok {
+			value = number
+			multiplier = 1 << (10 * (i + 1))
+			break
+		}
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid size '%s'", value)
+	}
+	*b = byteSize(size * multiplier)
+	return nil
+}
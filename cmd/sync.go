@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hermo/npmi-go/pkg/npmi"
+)
+
+// syncCaches copies entries between two caches and returns the exit code of the process
+func syncCaches(ctx context.Context, options *npmi.Options, commandOptions *commandOptions, log hclog.Logger) int {
+	if commandOptions.from == "" || commandOptions.to == "" {
+		log.Error("Both -from and -to must be given")
+		return 2
+	}
+	if commandOptions.from == commandOptions.to && commandOptions.fromConfig == commandOptions.toConfig {
+		log.Error("Source and target caches are the same, use -from-config or -to-config to configure one of them")
+		return 2
+	}
+
+	source, err := syncCacheOptions(options, commandOptions.from, commandOptions.fromConfig)
+	if err != nil {
+		log.Error("Invalid source cache", "error", err)
+		return 2
+	}
+	target, err := syncCacheOptions(options, commandOptions.to, commandOptions.toConfig)
+	if err != nil {
+		log.Error("Invalid target cache", "error", err)
+		return 2
+	}
+
+	result, err := npmi.Sync(ctx, source, target, &commandOptions.sync, log)
+	if result != nil {
+		log.Info("Sync complete", "copied", result.Copied, "skipped", result.Skipped, "failed", result.Failed, "bytes", result.Bytes, "dryRun", commandOptions.sync.DryRun)
+	}
+	if err != nil {
+		log.Error("Sync failed", "error", err)
+		return exitCode(ctx)
+	}
+	return 0
+}
+
+// syncCacheOptions returns the options of one side of a sync. Settings in configFile
+// override the ones given otherwise.
+func syncCacheOptions(options *npmi.Options, name string, configFile string) (*npmi.Options, error) {
+	cacheOptions, err := npmi.SyncCacheOptions(options, name)
+	if err != nil {
+		return nil, err
+	}
+	if configFile != "" {
+		if err := npmi.LoadConfigFile(configFile, cacheOptions); err != nil {
+			return nil, err
+		}
+		// The config file may enable other caches, only the named one is used
+		return npmi.SyncCacheOptions(cacheOptions, name)
+	}
+	return cacheOptions, nil
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hermo/npmi-go/pkg/npmi"
+)
+
+// exportEntry exports a cache entry to a file and returns the exit code of the process
+func exportEntry(ctx context.Context, options *npmi.Options, commandOptions *commandOptions, args []string, log hclog.Logger) int {
+	if commandOptions.outputFile == "" {
+		log.Error("Output file must be given using -o")
+		return 2
+	}
+	var cacheKey string
+	switch {
+	case commandOptions.currentKey && len(args) == 0:
+	case !commandOptions.currentKey && len(args) == 1:
+		cacheKey = args[0]
+	default:
+		log.Error("Either a cache key or -current must be given")
+		return 2
+	}
+
+	m, err := newCacheCommandMain(ctx, options, cacheKey != "", log)
+	if err != nil {
+		log.Error("Initialization failed", "error", err)
+		return exitCode(ctx)
+	}
+
+	exportedKey, err := m.Export(ctx, cacheKey, commandOptions.outputFile)
+	if err != nil {
+		log.Error("Export failed", "error", err)
+		return exitCode(ctx)
+	}
+	log.Info("Cache entry exported", "cacheKey", exportedKey, "path", commandOptions.outputFile)
+	return 0
+}
+
+// importEntry imports a cache entry from a file and returns the exit code of the process
+func importEntry(ctx context.Context, options *npmi.Options, commandOptions *commandOptions, args []string, log hclog.Logger) int {
+	if len(args) != 1 {
+		log.Error("The file to import must be given")
+		return 2
+	}
+
+	m, err := newCacheCommandMain(ctx, options, true, log)
+	if err != nil {
+		log.Error("Initialization failed", "error", err)
+		return exitCode(ctx)
+	}
+
+	importedKey, err := m.Import(ctx, args[0], commandOptions.cacheKey)
+	if err != nil {
+		log.Error("Import failed", "error", err)
+		return exitCode(ctx)
+	}
+	log.Info("Cache entry imported", "cacheKey", importedKey, "path", args[0])
+	return 0
+}
+
+// transferer copies cache entries to and from files
+type transferer interface {
+	Export(ctx context.Context, cacheKey string, filename string) (string, error)
+	Import(ctx context.Context, filename string, cacheKey string) (string, error)
+}
+
+// newCacheCommandMain creates a NPMI main for commands working on cache entries.
+// Node and npm are only required when the key of the current project is needed.
+func newCacheCommandMain(ctx context.Context, options *npmi.Options, keyGiven bool, log hclog.Logger) (transferer, error) {
+	if keyGiven {
+		return npmi.NewWithConfig(options, &npmi.Config{}, log)
+	}
+	return npmi.New(ctx, options, log)
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hermo/npmi-go/pkg/npmi"
+)
+
+// verify compares node_modules against an archive and returns the exit code of the process
+func verify(ctx context.Context, options *npmi.Options, archiveFile string, log hclog.Logger) int {
+	m, err := npmi.New(ctx, options, log)
+	if err != nil {
+		log.Error("Initialization failed", "error", err)
+		return exitCode(ctx)
+	}
+
+	differences, err := m.Verify(ctx, archiveFile)
+	if err != nil {
+		log.Error("Verification failed", "error", err)
+		return exitCode(ctx)
+	}
+
+	for _, difference := range differences {
+		if difference.Details != "" {
+			log.Warn(difference.Kind, "path", difference.Path, "details", difference.Details)
+		} else {
+			log.Warn(difference.Kind, "path", difference.Path)
+		}
+	}
+
+	if len(differences) > 0 {
+		log.Error("node_modules differs from the archive", "differences", len(differences))
+		return 1
+	}
+	log.Info("node_modules matches the archive")
+	return 0
+}
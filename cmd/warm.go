@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hermo/npmi-go/pkg/npmi"
+)
+
+// warm caches the packages of the project for several Node.js versions and returns the exit code of the process
+func warm(ctx context.Context, options *npmi.Options, nodes []string, log hclog.Logger) int {
+	if len(nodes) == 0 {
+		log.Error("At least one node binary or version must be given")
+		return 2
+	}
+
+	results, err := npmi.Warm(ctx, options, nodes, log)
+	if err != nil {
+		log.Error("Warming failed", "error", err)
+		return exitCode(ctx)
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Error("Warming failed for some nodes", "failed", failed, "nodes", len(nodes))
+		return 1
+	}
+	return 0
+}
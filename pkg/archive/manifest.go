@@ -0,0 +1,293 @@
+package archive
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hermo/npmi-go/pkg/files"
+	"github.com/klauspost/pgzip"
+)
+
+// ManifestFilename is the name of the manifest entry written first to each archive.
+// Versions predating the manifest extract it like any other file.
+const ManifestFilename = ".npmi-manifest.json"
+
+// manifestFormat is the version of the manifest format
+const manifestFormat = 1
+
+// Compression is the codec archives are compressed with
+const Compression = "gzip"
+
+// Manifest describes the contents of an archive and what created it
+type Manifest struct {
+	Format      int    `json:"format"`
+	NpmiVersion string `json:"npmiVersion,omitempty"`
+	// Project is the identifier of the project the archive was created for
+	Project   string    `json:"project,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Host      string    `json:"host,omitempty"`
+	// CIJobURL is the URL of the CI job which created the archive
+	CIJobURL    string `json:"ciJobUrl,omitempty"`
+	NodeVersion string `json:"nodeVersion,omitempty"`
+	NpmVersion  string `json:"npmVersion,omitempty"`
+	CacheKey    string `json:"cacheKey,omitempty"`
+	// KeyComponents lists the inputs the cache key was created from
+	KeyComponents json.RawMessage `json:"keyComponents,omitempty"`
+	FileCount     int             `json:"fileCount"`
+	TotalSize     int64           `json:"totalSize"`
+	Files         []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry describes a single directory, file or symlink in an archive
+type ManifestEntry struct {
+	Path string `json:"path"`
+	// Type is one of "dir", "file" or "symlink"
+	Type     string      `json:"type"`
+	Mode     os.FileMode `json:"mode"`
+	Size     int64       `json:"size,omitempty"`
+	Digest   string      `json:"digest,omitempty"`
+	Linkname string      `json:"linkname,omitempty"`
+}
+
+// Manifest entry types
+const (
+	EntryDir     = "dir"
+	EntryFile    = "file"
+	EntrySymlink = "symlink"
+)
+
+// entries returns the manifest entries by path
+func (m *Manifest) entries() map[string]ManifestEntry {
+	entries := make(map[string]ManifestEntry, len(m.Files))
+	for _, entry := range m.Files {
+		entries[entry.Path] = entry
+	}
+	return entries
+}
+
+// newManifestEntry describes a directory, regular file or symlink on disk.
+// The digest of a regular file is given by the caller.
+func newManifestEntry(path string, fi os.FileInfo, link string, digest string) *ManifestEntry {
+	entry := &ManifestEntry{
+		Path: filepath.ToSlash(path),
+		Mode: fi.Mode().Perm(),
+	}
+	switch determinePathType(fi) {
+	case TypeDir:
+		entry.Type = EntryDir
+	case TypeLink:
+		entry.Type = EntrySymlink
+		entry.Linkname = filepath.ToSlash(link)
+	case TypeRegular:
+		entry.Type = EntryFile
+		entry.Size = fi.Size()
+		entry.Digest = digest
+	}
+	return entry
+}
+
+// fileDigest returns the SHA-256 digest of a file in the form sha256:<hex>
+func fileDigest(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return formatDigest(h.Sum(nil)), nil
+}
+
+func formatDigest(sum []byte) string {
+	return fmt.Sprintf("sha256:%x", sum)
+}
+
+// writeManifest writes the manifest as a tar entry
+func writeManifest(tw *tar.Writer, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Format:   tar.FormatPAX,
+		Typeflag: tar.TypeReg,
+		Name:     ManifestFilename,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  manifest.CreatedAt,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// readManifest reads a manifest from the contents of a tar entry
+func readManifest(r io.Reader) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if manifest.Format > manifestFormat {
+		return nil, fmt.Errorf("unsupported manifest format %d", manifest.Format)
+	}
+	return &manifest, nil
+}
+
+// ReadManifest reads the manifest of an archive. It returns nil if the archive has no manifest,
+// e.g. because it was created by an earlier version.
+func ReadManifest(reader io.Reader) (*Manifest, error) {
+	gzr, err := pgzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+
+	tr := tar.NewReader(gzr)
+	header, err := tr.Next()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if header.Name != ManifestFilename {
+		return nil, nil
+	}
+	return readManifest(tr)
+}
+
+// manifestValidator validates extracted entries against the manifest of an archive.
+// A nil validator accepts everything, as archives created by earlier versions have no manifest.
+type manifestValidator struct {
+	entries map[string]ManifestEntry
+	seen    map[string]bool
+}
+
+func newManifestValidator(manifest *Manifest) *manifestValidator {
+	return &manifestValidator{
+		entries: manifest.entries(),
+		seen:    make(map[string]bool, len(manifest.Files)),
+	}
+}
+
+// validateHeader checks that an entry is listed in the manifest with the same type
+func (v *manifestValidator) validateHeader(target string, header *tar.Header) error {
+	if v == nil {
+		return nil
+	}
+	entry, ok := v.entries[target]
+	if !ok {
+		return fmt.Errorf("archive does not match its manifest: %s not listed", target)
+	}
+	v.seen[target] = true
+
+	var entryType string
+	switch header.Typeflag {
+	case tar.TypeDir:
+		entryType = EntryDir
+	case tar.TypeReg:
+		entryType = EntryFile
+	case tar.TypeSymlink:
+		entryType = EntrySymlink
+	}
+	if entry.Type != entryType {
+		return fmt.Errorf("archive does not match its manifest: %s is a %s, want %s", target, entryType, entry.Type)
+	}
+	if entryType == EntryFile && entry.Size != header.Size {
+		return fmt.Errorf("archive does not match its manifest: %s has size %d, want %d", target, header.Size, entry.Size)
+	}
+	if entryType == EntrySymlink && entry.Linkname != filepath.ToSlash(header.Linkname) {
+		return fmt.Errorf("archive does not match its manifest: %s points to %s, want %s", target, header.Linkname, entry.Linkname)
+	}
+	return nil
+}
+
+// validateDigest checks the digest of an extracted file
+func (v *manifestValidator) validateDigest(target string, digest string) error {
+	if v == nil {
+		return nil
+	}
+	if want := v.entries[target].Digest; digest != want {
+		return fmt.Errorf("archive does not match its manifest: %s has digest %s, want %s", target, digest, want)
+	}
+	return nil
+}
+
+// validateComplete checks that all entries listed in the manifest were extracted
+func (v *manifestValidator) validateComplete() error {
+	if v == nil {
+		return nil
+	}
+	for path := range v.entries {
+		if !v.seen[path] {
+			return fmt.Errorf("archive does not match its manifest: %s missing", path)
+		}
+	}
+	return nil
+}
+
+// Validate reads a whole archive without extracting it and checks its entries against its manifest.
+// It returns the manifest, or nil if the archive has none.
+func Validate(ctx context.Context, reader io.Reader) (*Manifest, error) {
+	gzr, err := pgzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+
+	var manifest *Manifest
+	var validator *manifestValidator
+	tr := tar.NewReader(gzr)
+	for first := true; ; first = false {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if first && header.Name == ManifestFilename {
+			if manifest, err = readManifest(tr); err != nil {
+				return nil, err
+			}
+			validator = newManifestValidator(manifest)
+			continue
+		}
+
+		target := filepath.ToSlash(filepath.Clean(header.Name))
+		if err := validator.validateHeader(target, header); err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg {
+			digest := sha256.New()
+			if _, err := io.Copy(digest, files.NewContextReader(ctx, tr)); err != nil {
+				return nil, err
+			}
+			if err := validator.validateDigest(target, formatDigest(digest.Sum(nil))); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := validator.validateComplete(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
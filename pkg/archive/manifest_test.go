@@ -0,0 +1,209 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// chdir changes to dir for the duration of the test
+func chdir(t *testing.T, dir string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestCreateWithManifest(t *testing.T) {
+	src := t.TempDir()
+	chdir(t, src)
+	if err := os.MkdirAll("node_modules/a", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("node_modules/a/index.js", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a/index.js", "node_modules/link.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	archiveFile := filepath.Join(t.TempDir(), "archive.tgz")
+	manifest := &Manifest{NpmiVersion: "test", CacheKey: "key", KeyComponents: json.RawMessage(`{"platform":"p"}`)}
+	options := &TarOptions{}
+	if _, err := Create(context.Background(), archiveFile, "node_modules", manifest, options); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(archiveFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := ReadManifest(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil {
+		t.Fatal("Was expecting the archive to have a manifest")
+	}
+	var keyComponents bytes.Buffer
+	if err := json.Compact(&keyComponents, got.KeyComponents); err != nil {
+		t.Fatal(err)
+	}
+	if got.Format != manifestFormat || got.CacheKey != "key" || keyComponents.String() != `{"platform":"p"}` {
+		t.Errorf("Unexpected manifest %+v", got)
+	}
+	if got.FileCount != 2 || got.TotalSize != 5 || len(got.Files) != 4 {
+		t.Errorf("fileCount=%d totalSize=%d entries=%d, want 2, 5, 4", got.FileCount, got.TotalSize, len(got.Files))
+	}
+	entries := got.entries()
+	if entry := entries["node_modules/a/index.js"]; entry.Type != EntryFile || entry.Mode != 0644 || !strings.HasPrefix(entry.Digest, "sha256:") {
+		t.Errorf("Unexpected file entry %+v", entry)
+	}
+	if entry := entries["node_modules/link.js"]; entry.Type != EntrySymlink || entry.Linkname != "a/index.js" {
+		t.Errorf("Unexpected symlink entry %+v", entry)
+	}
+
+	// The manifest is not extracted, but used for validation
+	chdir(t, t.TempDir())
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	files, _, err := Extract(context.Background(), f, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("Was expecting 2 files to be extracted, got %v", files)
+	}
+	if _, err := os.Stat(ManifestFilename); !os.IsNotExist(err) {
+		t.Errorf("Was not expecting the manifest to be extracted")
+	}
+}
+
+func TestExtractAndValidateCheckManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest Manifest
+		wantErr  string
+	}{
+		{"valid", Manifest{Files: []ManifestEntry{
+			{Path: "dir", Type: EntryDir},
+			{Path: "dir/file.txt", Type: EntryFile, Size: 5, Digest: "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		}}, ""},
+		{"digest", Manifest{Files: []ManifestEntry{
+			{Path: "dir", Type: EntryDir},
+			{Path: "dir/file.txt", Type: EntryFile, Size: 5, Digest: "sha256:0000"},
+		}}, "has digest"},
+		{"not listed", Manifest{Files: []ManifestEntry{
+			{Path: "dir", Type: EntryDir},
+		}}, "not listed"},
+		{"missing", Manifest{Files: []ManifestEntry{
+			{Path: "dir", Type: EntryDir},
+			{Path: "dir/file.txt", Type: EntryFile, Size: 5, Digest: "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+			{Path: "dir/other.txt", Type: EntryFile},
+		}}, "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdir(t, t.TempDir())
+			if err := os.Mkdir("dir", 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile("dir/file.txt", []byte("old"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			gzw := gzip.NewWriter(&buf)
+			tw := tar.NewWriter(gzw)
+			if err := writeManifest(tw, &tt.manifest); err != nil {
+				t.Fatal(err)
+			}
+			if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "dir", Mode: 0755}); err != nil {
+				t.Fatal(err)
+			}
+			if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "dir/file.txt", Mode: 0644, Size: 5}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			tw.Close()
+			gzw.Close()
+
+			_, _, extractErr := Extract(context.Background(), bytes.NewReader(buf.Bytes()), &TarOptions{})
+			_, validateErr := Validate(context.Background(), bytes.NewReader(buf.Bytes()))
+			for name, err := range map[string]error{"Extract": extractErr, "Validate": validateErr} {
+				if tt.wantErr == "" {
+					if err != nil {
+						t.Errorf("%s() error = %v", name, err)
+					}
+					continue
+				}
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("%s() error = %v, want %q", name, err, tt.wantErr)
+				}
+			}
+
+			// Files are replaced only once the whole archive has been validated
+			wantContent := "old"
+			if tt.wantErr == "" {
+				wantContent = "hello"
+			}
+			if data, err := os.ReadFile("dir/file.txt"); err != nil || string(data) != wantContent {
+				t.Errorf("dir/file.txt = %q, %v, want %q", data, err, wantContent)
+			}
+			if matches, _ := filepath.Glob("dir/.*.npmi-*"); len(matches) != 0 {
+				t.Errorf("Was not expecting temporary files to be left behind, got %v", matches)
+			}
+		})
+	}
+}
+
+func TestCreateAndExtractInDir(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "node_modules", "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "node_modules", "a", "index.js"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a/index.js", filepath.Join(src, "node_modules", "link.js")); err != nil {
+		t.Fatal(err)
+	}
+	// Paths are relative to the directory, not to the current directory
+	chdir(t, t.TempDir())
+
+	archiveFile := filepath.Join(t.TempDir(), "archive.tgz")
+	if _, err := Create(context.Background(), archiveFile, "node_modules", &Manifest{}, &TarOptions{Dir: src}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(archiveFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dst := t.TempDir()
+	extracted, _, err := Extract(context.Background(), f, &TarOptions{Dir: dst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"node_modules/a/index.js", "node_modules/link.js"}; strings.Join(extracted, ",") != strings.Join(want, ",") {
+		t.Errorf("Extract() = %v, want %v", extracted, want)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "node_modules", "link.js")); err != nil || string(data) != "hello" {
+		t.Errorf("node_modules/link.js = %q, %v, want hello", data, err)
+	}
+}
@@ -2,6 +2,8 @@ package archive
 
 import (
 	"archive/tar"
+	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
@@ -10,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/hermo/npmi-go/pkg/files"
 	"github.com/klauspost/pgzip"
 )
 
@@ -17,35 +20,142 @@ type TarOptions struct {
 	AllowAbsolutePaths   bool
 	AllowDoubleDotPaths  bool
 	AllowLinksOutsideCwd bool
+	// Dir is the directory the paths in the archive are relative to. Empty means the current directory.
+	Dir string
 }
 
-// Create an archive file containing the contents of directory src
-func Create(filename string, src string, options *TarOptions) (warnings []string, err error) {
+// Create an archive file containing the contents of directory src, relative to options.Dir.
+// If manifest is given, it is completed with the entries of the archive and
+// written as its first entry. The digests of the files are computed while
+// they are written to a temporary file, which is then appended to the
+// manifest as a second gzip stream.
+// The partially written archive is removed if creation fails or ctx is cancelled.
+func Create(ctx context.Context, filename string, src string, manifest *Manifest, options *TarOptions) (warnings []string, err error) {
 	f, err := os.Create(filename)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(filename)
+		}
+	}()
 
-	if _, err := os.Stat(src); err != nil {
+	if _, err := os.Stat(filepath.Join(options.Dir, src)); err != nil {
 		return nil, fmt.Errorf("TAR: %v", err.Error())
 	}
 
-	gzw := pgzip.NewWriter(f)
-	defer gzw.Close()
+	archiveInfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, warnings, err := collectEntries(ctx, src, archiveInfo, options)
+	if err != nil {
+		return warnings, err
+	}
+
+	if manifest == nil {
+		_, err := writeEntries(ctx, f, entries)
+		return warnings, err
+	}
+
+	body, err := os.CreateTemp(filepath.Dir(filename), ".npmi-entries-*")
+	if err != nil {
+		return warnings, err
+	}
+	defer func() {
+		body.Close()
+		os.Remove(body.Name())
+	}()
+
+	digests, err := writeEntries(ctx, body, entries)
+	if err != nil {
+		return warnings, err
+	}
+	completeManifest(manifest, entries, digests)
+
+	if err := writeManifestStream(f, manifest); err != nil {
+		return warnings, err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return warnings, err
+	}
+	_, err = io.Copy(f, files.NewContextReader(ctx, body))
+	return warnings, err
+}
+
+// writeEntries writes entries to w as a compressed tar stream and returns the digests of the regular files by path
+func writeEntries(ctx context.Context, w io.Writer, entries []archiveEntry) (digests map[string]string, err error) {
+	gzw := pgzip.NewWriter(w)
+	defer func() {
+		if cerr := gzw.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	tw := tar.NewWriter(gzw)
+	defer func() {
+		if cerr := tw.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	digests = make(map[string]string)
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		digest, err := writeEntry(ctx, tw, entry)
+		if err != nil {
+			return nil, err
+		}
+		if digest != "" {
+			digests[entry.name] = digest
+		}
+	}
+	return digests, nil
+}
 
+// writeManifestStream writes the manifest to w as a compressed tar stream without an end-of-archive
+// marker, so that the entries of the archive can follow it in a second gzip stream
+func writeManifestStream(w io.Writer, manifest *Manifest) error {
+	gzw := pgzip.NewWriter(w)
 	tw := tar.NewWriter(gzw)
-	defer tw.Close()
+	if err := writeManifest(tw, manifest); err != nil {
+		gzw.Close()
+		return err
+	}
+	if err := tw.Flush(); err != nil {
+		gzw.Close()
+		return err
+	}
+	return gzw.Close()
+}
+
+// archiveEntry is a path to be added to an archive
+type archiveEntry struct {
+	// path is the path on disk and name the path in the archive
+	path     string
+	name     string
+	fi       os.FileInfo
+	link     string
+	pathType FileType
+}
 
+// collectEntries walks directory src and returns the paths to be added to an archive,
+// skipping the archive file itself
+func collectEntries(ctx context.Context, src string, archiveInfo os.FileInfo, options *TarOptions) (entries []archiveEntry, warnings []string, err error) {
 	badPath := NewBadPath(options.AllowDoubleDotPaths, options.AllowAbsolutePaths)
 
-	wd, err := os.Getwd()
+	wd, err := filepath.Abs(options.Dir)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// walk path
-	err = filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+	err = filepath.Walk(filepath.Join(options.Dir, src), func(path string, fi os.FileInfo, err error) error {
 		var link string
 
 		// return on any error
@@ -53,6 +163,21 @@ func Create(filename string, src string, options *TarOptions) (warnings []string
 			return err
 		}
 
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if os.SameFile(fi, archiveInfo) {
+			return nil
+		}
+
+		name := path
+		if options.Dir != "" {
+			if name, err = filepath.Rel(options.Dir, path); err != nil {
+				return err
+			}
+		}
+
 		pathType := determinePathType(fi)
 		// Ignore unknown types
 		if pathType == TypeOther {
@@ -66,7 +191,7 @@ func Create(filename string, src string, options *TarOptions) (warnings []string
 				return err
 			}
 
-			pathDir := filepath.Dir(path)
+			pathDir := filepath.Dir(name)
 			var linkFull string
 
 			if filepath.IsAbs(link) {
@@ -100,50 +225,72 @@ func Create(filename string, src string, options *TarOptions) (warnings []string
 			}
 		}
 
-		// create a new dir/file header
-		header, err := tar.FileInfoHeader(fi, link)
-		if err != nil {
-			return err
-		}
-
-		// Use PAX format for utf-8 support
-		header.Format = tar.FormatPAX
+		entries = append(entries, archiveEntry{path: path, name: name, fi: fi, link: link, pathType: pathType})
+		return nil
+	})
+	return entries, warnings, err
+}
 
-		if pathType == TypeLink {
-			header.Typeflag = tar.TypeSymlink
-			header.Linkname = link
+// completeManifest adds the entries of an archive and the digests of its files to its manifest
+func completeManifest(manifest *Manifest, entries []archiveEntry, digests map[string]string) {
+	manifest.Format = manifestFormat
+	manifest.Files = make([]ManifestEntry, 0, len(entries))
+	manifest.FileCount = 0
+	manifest.TotalSize = 0
+
+	for _, entry := range entries {
+		manifestEntry := newManifestEntry(entry.name, entry.fi, entry.link, digests[entry.name])
+		if manifestEntry.Type != EntryDir {
+			manifest.FileCount++
+			manifest.TotalSize += manifestEntry.Size
 		}
+		manifest.Files = append(manifest.Files, *manifestEntry)
+	}
+}
 
-		header.Name = filepath.ToSlash(path)
-		header.Linkname = filepath.ToSlash(header.Linkname)
+// writeEntry writes the header and contents of a path to an archive.
+// It returns the digest of the contents of a regular file.
+func writeEntry(ctx context.Context, tw *tar.Writer, entry archiveEntry) (string, error) {
+	// create a new dir/file header
+	header, err := tar.FileInfoHeader(entry.fi, entry.link)
+	if err != nil {
+		return "", err
+	}
 
-		// write the header
-		if err := tw.WriteHeader(header); err != nil {
-			return err
-		}
+	// Use PAX format for utf-8 support
+	header.Format = tar.FormatPAX
 
-		// No further work required for directories
-		if pathType != TypeRegular {
-			return nil
-		}
+	if entry.pathType == TypeLink {
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = entry.link
+	}
 
-		// Add file to archive
-		f, err := os.Open(header.Name)
-		if err != nil {
-			return err
-		}
+	header.Name = filepath.ToSlash(entry.name)
+	header.Linkname = filepath.ToSlash(header.Linkname)
 
-		if _, err := io.Copy(tw, f); err != nil {
-			return err
-		}
+	// write the header
+	if err := tw.WriteHeader(header); err != nil {
+		return "", err
+	}
 
-		// manually close here after each file operation; defering would cause each file close
-		// to wait until all operations have completed.
-		f.Close()
+	// No further work required for directories
+	if entry.pathType != TypeRegular {
+		return "", nil
+	}
 
-		return nil
-	})
-	return warnings, err
+	// Add file to archive
+	f, err := os.Open(entry.path)
+	if err != nil {
+		return "", err
+	}
+	// Each file is closed before the next one is opened, as entries are written one at a time
+	defer f.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tw, digest), files.NewContextReader(ctx, f)); err != nil {
+		return "", err
+	}
+	return formatDigest(digest.Sum(nil)), nil
 }
 
 type badpath struct {
@@ -176,13 +323,28 @@ func (bp *badpath) IsBad(path string) bool {
 	return false
 }
 
-// Extract all files from an archive to current directory
-func Extract(reader io.Reader, options *TarOptions) (manifest []string, warnings []string, err error) {
-	cwd, err := os.Getwd()
+// Extract all files from an archive to options.Dir, or the current directory if it is empty.
+// The returned manifest lists the extracted paths relative to that directory.
+// If the archive starts with a manifest, the extracted entries are validated against it.
+// Files are written to temporary files and, like symlinks, put into place only after the
+// whole archive has been read and validated, so that a truncated or invalid archive
+// leaves existing files untouched.
+func Extract(ctx context.Context, reader io.Reader, options *TarOptions) (manifest []string, warnings []string, err error) {
+	cwd, err := filepath.Abs(options.Dir)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	// Files and symlinks put into place once the archive has been validated, in archive order
+	var pending []pendingEntry
+	defer func() {
+		for _, entry := range pending {
+			if entry.tempFile != "" {
+				os.Remove(entry.tempFile)
+			}
+		}
+	}()
+
 	gzr, err := pgzip.NewReaderN(reader, 500e3, 50)
 	if err != nil {
 		return nil, nil, err
@@ -191,8 +353,13 @@ func Extract(reader io.Reader, options *TarOptions) (manifest []string, warnings
 
 	tr := tar.NewReader(gzr)
 
+	var validator *manifestValidator
 	badPath := NewBadPath(false, false)
-	for {
+	for first := true; ; first = false {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
 		header, err := tr.Next()
 		if err == io.EOF {
 			break
@@ -208,6 +375,15 @@ func Extract(reader io.Reader, options *TarOptions) (manifest []string, warnings
 			continue
 		}
 
+		if first && header.Name == ManifestFilename {
+			archiveManifest, err := readManifest(tr)
+			if err != nil {
+				return nil, nil, err
+			}
+			validator = newManifestValidator(archiveManifest)
+			continue
+		}
+
 		// the target location where the dir/file should be created
 		target := header.Name
 		target = filepath.ToSlash(filepath.Clean(target))
@@ -216,48 +392,36 @@ func Extract(reader io.Reader, options *TarOptions) (manifest []string, warnings
 			return nil, nil, fmt.Errorf("invalid path: contains bad characters")
 		}
 
+		if err := validator.validateHeader(target, header); err != nil {
+			return nil, nil, err
+		}
+		targetPath := filepath.Join(cwd, target)
+
 		// check the file type
 		switch header.Typeflag {
 
 		// if its a dir and it doesn't exist create it
 		case tar.TypeDir:
-			if _, err := os.Stat(target); err != nil {
-				if err := os.MkdirAll(target, header.FileInfo().Mode()); err != nil {
+			if _, err := os.Stat(targetPath); err != nil {
+				if err := os.MkdirAll(targetPath, header.FileInfo().Mode()); err != nil {
 					return nil, nil, err
 				}
 			}
 
 			// Defer setting directory mtimes as they are bound to change when files are written to them
 			defer func() {
-				if err := os.Chtimes(target, time.Now(), header.FileInfo().ModTime()); err != nil {
+				if err := os.Chtimes(targetPath, time.Now(), header.FileInfo().ModTime()); err != nil {
 					fmt.Fprintf(os.Stderr, "Error: Could not restore mtime for directory %s: %v", target, err)
 				}
 			}()
 
 		// if it's a file create it
 		case tar.TypeReg:
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
+			tempFile, err := extractFile(ctx, tr, targetPath, target, header, validator)
 			if err != nil {
 				return nil, nil, err
 			}
-
-			// copy over contents
-			if _, err := io.Copy(f, tr); err != nil {
-				f.Close()
-				return nil, nil, err
-			}
-
-			// manually close here after each file operation; defering would cause each file close
-			// to wait until all operations have completed.
-			f.Close()
-			if err = os.Chtimes(target, time.Now(), header.FileInfo().ModTime()); err != nil {
-				return nil, nil, err
-			}
-
-			if err = os.Chmod(target, header.FileInfo().Mode()); err != nil {
-				return nil, nil, err
-			}
-
+			pending = append(pending, pendingEntry{path: targetPath, tempFile: tempFile})
 			manifest = append(manifest, target)
 
 		case tar.TypeSymlink:
@@ -287,10 +451,7 @@ func Extract(reader io.Reader, options *TarOptions) (manifest []string, warnings
 				}
 			}
 
-			err = syncSymLink(source, dest)
-			if err != nil {
-				return nil, nil, fmt.Errorf("syncing symlink failed: %v", err)
-			}
+			pending = append(pending, pendingEntry{path: dest, linkname: source})
 
 			// symlink timestamps are not preserved
 			// see https://stackoverflow.com/questions/54762079/how-to-change-timestamp-for-symbol-link-using-golang
@@ -301,9 +462,70 @@ func Extract(reader io.Reader, options *TarOptions) (manifest []string, warnings
 		}
 
 	}
+
+	if err := validator.validateComplete(); err != nil {
+		return nil, nil, err
+	}
+
+	for i, entry := range pending {
+		if entry.tempFile == "" {
+			if err := syncSymLink(entry.linkname, entry.path); err != nil {
+				return nil, nil, fmt.Errorf("syncing symlink failed: %v", err)
+			}
+			continue
+		}
+		if err := os.Rename(entry.tempFile, entry.path); err != nil {
+			return nil, nil, err
+		}
+		pending[i].tempFile = ""
+	}
 	return manifest, warnings, nil
 }
 
+// pendingEntry is a file extracted to tempFile or a symlink to linkname, which is put
+// into place at path once the whole archive has been validated
+type pendingEntry struct {
+	path     string
+	tempFile string
+	linkname string
+}
+
+// extractFile writes the contents of file entry target to a temporary file next to path and
+// returns its name once its digest has been validated. The temporary file is removed on error.
+func extractFile(ctx context.Context, r io.Reader, path string, target string, header *tar.Header, validator *manifestValidator) (tempFile string, err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".npmi-*")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	// copy over contents
+	digest := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, digest), files.NewContextReader(ctx, r)); err != nil {
+		return "", err
+	}
+	if err := validator.validateDigest(target, formatDigest(digest.Sum(nil))); err != nil {
+		return "", err
+	}
+
+	// close before restoring the mtime, as the pending writes would update it
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(f.Name(), header.FileInfo().Mode()); err != nil {
+		return "", err
+	}
+	if err := os.Chtimes(f.Name(), time.Now(), header.FileInfo().ModTime()); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
 type FileType int
 
 const (
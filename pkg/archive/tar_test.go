@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -109,7 +110,7 @@ func Test_ExtractFilesNormal(t *testing.T) {
 		AllowLinksOutsideCwd: false,
 	}
 
-	manifest, warnings, err := Extract(&buf, &options)
+	manifest, warnings, err := Extract(context.Background(), &buf, &options)
 	if err != nil {
 		t.Fatalf("Extract failed: %v", err)
 	}
@@ -244,7 +245,7 @@ func Test_ExtractFilesEvil(t *testing.T) {
 				AllowLinksOutsideCwd: false,
 			}
 
-			_, warnings, err := Extract(&buf, &options)
+			_, warnings, err := Extract(context.Background(), &buf, &options)
 			if len(warnings) != tt.WantedWarnings {
 				t.Errorf("Expected %d warnings, got %d", tt.WantedWarnings, len(warnings))
 			}
@@ -260,6 +261,70 @@ func Test_ExtractFilesEvil(t *testing.T) {
 	}
 }
 
+func Test_CreateCancelled(t *testing.T) {
+	testDir, err := prepareTestDir()
+	if err != nil {
+		t.Fatalf("Can't create temporary test directory: %v", err)
+	}
+
+	defer removeTestDir(testDir)
+
+	if err = os.Mkdir("src", 0700); err != nil {
+		t.Fatalf("Could not create directory: %v", err)
+	}
+	if err = os.WriteFile("src/file.txt", []byte("content"), 0644); err != nil {
+		t.Fatalf("Could not create file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	options := TarOptions{}
+	_, err = Create(ctx, "temp.tgz", "src", nil, &options)
+	if err != context.Canceled {
+		t.Fatalf("Create error=%v, want=%v", err, context.Canceled)
+	}
+
+	if _, err := os.Stat("temp.tgz"); !os.IsNotExist(err) {
+		t.Fatalf("Partial archive should have been removed, stat error=%v", err)
+	}
+}
+
+func Test_ExtractCancelled(t *testing.T) {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	data := []byte("content")
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "file.txt", Size: int64(len(data)), Mode: 0644}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	gzw.Close()
+
+	testDir, err := prepareTestDir()
+	if err != nil {
+		t.Fatalf("Can't create temporary test directory: %v", err)
+	}
+
+	defer removeTestDir(testDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	options := TarOptions{}
+	_, _, err = Extract(ctx, &buf, &options)
+	if err != context.Canceled {
+		t.Fatalf("Extract error=%v, want=%v", err, context.Canceled)
+	}
+
+	if _, err := os.Stat("file.txt"); !os.IsNotExist(err) {
+		t.Fatalf("Nothing should have been extracted, stat error=%v", err)
+	}
+}
+
 // Some tests for disallowing bad symlinks
 func Test_CreateArchiveSymlinks(t *testing.T) {
 	tests := []struct {
@@ -317,7 +382,7 @@ func Test_CreateArchiveSymlinks(t *testing.T) {
 				AllowDoubleDotPaths:  true,
 				AllowLinksOutsideCwd: true,
 			}
-			warnings, err := Create("temp.tgz", ".", &options)
+			warnings, err := Create(context.Background(), "temp.tgz", ".", nil, &options)
 
 			if len(warnings) != tt.WarningCount {
 				t.Errorf("Expected %d warnings, got %d", tt.WarningCount, len(warnings))
@@ -400,7 +465,7 @@ func Test_CreateArchive_DisallowSymlinksOutsideCwd(t *testing.T) {
 				AllowDoubleDotPaths:  true,
 				AllowLinksOutsideCwd: false,
 			}
-			warnings, err := Create("temp.tgz", ".", &options)
+			warnings, err := Create(context.Background(), "temp.tgz", ".", nil, &options)
 
 			if len(warnings) != tt.WarningCount {
 				t.Errorf("Expected %d warnings, got only %d", tt.WarningCount, len(warnings))
@@ -495,7 +560,7 @@ func BenchmarkExtract(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		f.Seek(0, io.SeekStart)
 
-		_, _, err := Extract(f, &options)
+		_, _, err := Extract(context.Background(), f, &options)
 		if err != nil {
 			b.Fatalf("Extract failed: %v", err)
 		}
@@ -663,14 +728,14 @@ func BenchmarkCreate(b *testing.B) {
 		AllowLinksOutsideCwd: false,
 	}
 
-	_, _, err = Extract(f, &options)
+	_, _, err = Extract(context.Background(), f, &options)
 	if err != nil {
 		b.Fatalf("Extract failed: %v", err)
 	}
 	f.Close()
 
 	for i := 0; i < b.N; i++ {
-		warnings, err := Create("compressed.tgz", "node_modules", &options)
+		warnings, err := Create(context.Background(), "compressed.tgz", "node_modules", nil, &options)
 		if err != nil {
 			b.Fatalf("Create failed: %v", err)
 		}
@@ -0,0 +1,160 @@
+package archive
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hermo/npmi-go/pkg/files"
+	"github.com/klauspost/pgzip"
+)
+
+// Kinds of differences between an archive and the file system
+const (
+	Added   = "added"
+	Missing = "missing"
+	Changed = "changed"
+)
+
+// Difference describes a file or symlink which differs between an archive and the file system
+type Difference struct {
+	Path string
+	// Kind is one of Added, Missing or Changed
+	Kind string
+	// Details describes what changed
+	Details string
+}
+
+// Verify compares the files and symlinks in an archive against directory on disk.
+// Directories are not compared, as extraction neither removes extra directories
+// nor restores their modes exactly.
+func Verify(ctx context.Context, reader io.Reader, directory string) ([]Difference, error) {
+	archived, err := readEntries(ctx, reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't read archive: %v", err)
+	}
+
+	var differences []Difference
+	seen := make(map[string]bool, len(archived))
+	err = files.WalkFilesAndSymlinks(directory, func(file string, fi os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			var err error
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		var digest string
+		if fi.Mode().IsRegular() {
+			var err error
+			if digest, err = fileDigest(file); err != nil {
+				return err
+			}
+		}
+		onDisk := newManifestEntry(file, fi, link, digest)
+
+		want, ok := archived[onDisk.Path]
+		if !ok {
+			differences = append(differences, Difference{Path: onDisk.Path, Kind: Added})
+			return nil
+		}
+		seen[onDisk.Path] = true
+		if details := compareEntries(want, *onDisk); details != "" {
+			differences = append(differences, Difference{Path: onDisk.Path, Kind: Changed, Details: details})
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for path := range archived {
+		if !seen[path] {
+			differences = append(differences, Difference{Path: path, Kind: Missing})
+		}
+	}
+
+	sort.Slice(differences, func(i, j int) bool {
+		return differences[i].Path < differences[j].Path
+	})
+	return differences, nil
+}
+
+// compareEntries describes how an entry on disk differs from the archived one.
+// It returns an empty string if they match.
+func compareEntries(want ManifestEntry, got ManifestEntry) string {
+	switch {
+	case want.Type != got.Type:
+		return fmt.Sprintf("type %s, want %s", got.Type, want.Type)
+	case want.Type == EntrySymlink && want.Linkname != got.Linkname:
+		return fmt.Sprintf("points to %s, want %s", got.Linkname, want.Linkname)
+	case want.Type == EntryFile && want.Size != got.Size:
+		return fmt.Sprintf("size %d, want %d", got.Size, want.Size)
+	case want.Type == EntryFile && want.Digest != got.Digest:
+		return fmt.Sprintf("digest %s, want %s", got.Digest, want.Digest)
+	case want.Type == EntryFile && want.Mode != got.Mode:
+		return fmt.Sprintf("mode %s, want %s", got.Mode, want.Mode)
+	}
+	return ""
+}
+
+// readEntries reads the files and symlinks of an archive, computing the digests of files
+func readEntries(ctx context.Context, reader io.Reader) (map[string]ManifestEntry, error) {
+	gzr, err := pgzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+
+	entries := map[string]ManifestEntry{}
+	tr := tar.NewReader(gzr)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		path := filepath.ToSlash(filepath.Clean(header.Name))
+		if path == ManifestFilename {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeReg:
+			digest := sha256.New()
+			if _, err := io.Copy(digest, files.NewContextReader(ctx, tr)); err != nil {
+				return nil, err
+			}
+			entries[path] = ManifestEntry{
+				Path:   path,
+				Type:   EntryFile,
+				Mode:   header.FileInfo().Mode().Perm(),
+				Size:   header.Size,
+				Digest: formatDigest(digest.Sum(nil)),
+			}
+		case tar.TypeSymlink:
+			entries[path] = ManifestEntry{
+				Path:     path,
+				Type:     EntrySymlink,
+				Linkname: filepath.ToSlash(header.Linkname),
+			}
+		}
+	}
+	return entries, nil
+}
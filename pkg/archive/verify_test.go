@@ -0,0 +1,88 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	chdir(t, t.TempDir())
+	for name, content := range map[string]string{
+		"node_modules/a/index.js": "hello",
+		"node_modules/b/index.js": "world",
+		"node_modules/c/index.js": "!",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a/index.js", "node_modules/link.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	archiveFile := filepath.Join(t.TempDir(), "archive.tgz")
+	if _, err := Create(context.Background(), archiveFile, "node_modules", &Manifest{}, &TarOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func() []Difference {
+		f, err := os.Open(archiveFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		differences, err := Verify(context.Background(), f, "node_modules")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return differences
+	}
+
+	if differences := verify(); len(differences) != 0 {
+		t.Fatalf("Was expecting no differences, got %+v", differences)
+	}
+
+	if err := os.WriteFile("node_modules/a/index.js", []byte("HELLO"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod("node_modules/b/index.js", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove("node_modules/c/index.js"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("node_modules/new.js", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove("node_modules/link.js"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("b/index.js", "node_modules/link.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Difference{
+		{Path: "node_modules/a/index.js", Kind: Changed},
+		{Path: "node_modules/b/index.js", Kind: Changed},
+		{Path: "node_modules/c/index.js", Kind: Missing},
+		{Path: "node_modules/link.js", Kind: Changed},
+		{Path: "node_modules/new.js", Kind: Added},
+	}
+	got := verify()
+	if len(got) != len(want) {
+		t.Fatalf("Was expecting %d differences, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i].Path != want[i].Path || got[i].Kind != want[i].Kind {
+			t.Errorf("Difference %d = %+v, want %+v", i, got[i], want[i])
+		}
+		if got[i].Kind == Changed && got[i].Details == "" {
+			t.Errorf("Was expecting details for %s", got[i].Path)
+		}
+	}
+}
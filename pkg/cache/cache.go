@@ -1,29 +1,85 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"strings"
+	"time"
 
 	"github.com/hermo/npmi-go/pkg/hash"
 )
 
 // Cacher represents a cache
 type Cacher interface {
-	Has(key string) (bool, error)
-	Put(key string, reader io.Reader) error
-	Get(key string) (io.Reader, error)
+	Has(ctx context.Context, key string) (bool, error)
+	Put(ctx context.Context, key string, reader io.Reader) error
+	Get(ctx context.Context, key string) (io.Reader, error)
 }
 
-func CreateKey(platformKey string, lockFileHash string, precacheCommand string) (string, error) {
+// Locker is implemented by caches which can coordinate concurrent builds of the same key
+type Locker interface {
+	// TryLock tries to take the build lock of a key without waiting. It returns
+	// ok=false if someone else holds the lock. unlock releases a lock taken.
+	TryLock(ctx context.Context, key string) (unlock func() error, ok bool, err error)
+}
+
+// CreateKey creates a cache key from the platform, the lockfile hash, the precache command
+// and any extra inputs such as hashes of additional files
+func CreateKey(platformKey string, lockFileHash string, precacheCommand string, inputs ...string) (string, error) {
 	cacheKey := fmt.Sprintf("%s-%s", platformKey, lockFileHash)
-	if precacheCommand == "" {
-		return cacheKey, nil
-	}
-	precacheHash, err := hash.String(precacheCommand)
-	if err != nil {
-		return "", fmt.Errorf("could not hash precache command: %v", err)
+	if precacheCommand != "" {
+		precacheHash, err := hash.String(precacheCommand)
+		if err != nil {
+			return "", fmt.Errorf("could not hash precache command: %v", err)
+		}
+		cacheKey = fmt.Sprintf("%s-%s", cacheKey, precacheHash)
 	}
 
-	cacheKey = fmt.Sprintf("%s-%s", cacheKey, precacheHash)
+	if len(inputs) > 0 {
+		inputsHash, err := hash.String(strings.Join(inputs, "\n"))
+		if err != nil {
+			return "", fmt.Errorf("could not hash key inputs: %v", err)
+		}
+		cacheKey = fmt.Sprintf("%s-%s", cacheKey, inputsHash)
+	}
 	return cacheKey, nil
 }
+
+// Entry describes an entry stored in a cache
+type Entry struct {
+	Key      string
+	Size     int64
+	ModTime  time.Time
+	Metadata Metadata
+}
+
+// Lister is implemented by caches which can enumerate their entries
+type Lister interface {
+	// List returns the entries whose keys start with prefix. Lock and temporary
+	// files are not included.
+	List(ctx context.Context, prefix string) ([]Entry, error)
+}
+
+// Metadata describes what created an entry using lowercase names such as npmi-version
+type Metadata map[string]string
+
+// MetadataPutter is implemented by caches which can store metadata along with an entry
+type MetadataPutter interface {
+	PutWithMetadata(ctx context.Context, key string, reader io.Reader, metadata Metadata) error
+}
+
+// Stater is implemented by caches which can describe a single entry
+type Stater interface {
+	// Stat returns the entry of a key including any metadata stored with it, or nil
+	// if the cache doesn't contain the key
+	Stat(ctx context.Context, key string) (*Entry, error)
+}
+
+// PutWithMetadata stores something in a cache along with metadata if the cache supports it
+func PutWithMetadata(ctx context.Context, cache Cacher, key string, reader io.Reader, metadata Metadata) error {
+	if putter, ok := cache.(MetadataPutter); ok && len(metadata) > 0 {
+		return putter.PutWithMetadata(ctx, key, reader, metadata)
+	}
+	return cache.Put(ctx, key, reader)
+}
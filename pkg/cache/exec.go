@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hermo/npmi-go/pkg/cmd"
+)
+
+// execCache is a cache implemented by an external executable, a plugin. The plugin is run as
+//
+//	<command> [args...] has <key>  prints {"found": true} or {"found": false}
+//	<command> [args...] get <key>  prints the entry
+//	<command> [args...] put <key>  reads the entry from stdin
+//
+// and exits with a non-zero exit code on failure. It may print {"error": "<message>"}
+// as the last line of stderr to describe a failure.
+type execCache struct {
+	command string
+	args    []string
+	log     hclog.Logger
+}
+
+// execStatus is the JSON status printed by a plugin
+type execStatus struct {
+	Found bool   `json:"found"`
+	Error string `json:"error"`
+}
+
+// NewExecCache creates a Cacher, which stores data using a plugin executable
+func NewExecCache(command string, args []string, log hclog.Logger) (Cacher, error) {
+	if command == "" {
+		return nil, fmt.Errorf("no plugin command given")
+	}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf("plugin not found: %v", err)
+	}
+	return &execCache{path, args, log}, nil
+}
+
+// newCommand creates the command of an operation. When ctx is cancelled, the plugin
+// and its children are terminated like other commands run by npmi-go.
+func (cache *execCache) newCommand(ctx context.Context, operation string, key string) *exec.Cmd {
+	args := append(append([]string{}, cache.args...), operation, key)
+	c := exec.CommandContext(ctx, cache.command, args...)
+	cmd.SetCancelBehaviour(c)
+	return c
+}
+
+// Has asks the plugin whether it has a given key
+func (cache *execCache) Has(ctx context.Context, key string) (bool, error) {
+	log := cache.log.Named("has")
+	log.Trace("start", "key", key)
+
+	c := cache.newCommand(ctx, "has", key)
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		err = pluginError("has", err, stderr.String())
+		log.Error("failed", "error", err)
+		return false, err
+	}
+
+	var status execStatus
+	if err := json.Unmarshal(stdout.Bytes(), &status); err != nil {
+		err = fmt.Errorf("invalid status from plugin: %v", err)
+		log.Error("failed", "error", err)
+		return false, err
+	}
+	log.Trace("complete", "found", status.Found)
+	return status.Found, nil
+}
+
+// Get fetches something using the plugin. The returned reader must be closed.
+func (cache *execCache) Get(ctx context.Context, key string) (io.Reader, error) {
+	log := cache.log.Named("get")
+	log.Trace("start", "key", key)
+
+	ctx, cancel := context.WithCancel(ctx)
+	c := cache.newCommand(ctx, "get", key)
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	reader := &execReader{cmd: c, stdout: stdout, cancel: cancel}
+	c.Stderr = &reader.stderr
+	if err := c.Start(); err != nil {
+		cancel()
+		log.Error("failed", "error", err)
+		return nil, err
+	}
+	return reader, nil
+}
+
+// Put stores something using the plugin
+func (cache *execCache) Put(ctx context.Context, key string, reader io.Reader) error {
+	log := cache.log.Named("put")
+	log.Trace("start", "key", key)
+
+	c := cache.newCommand(ctx, "put", key)
+	c.Stdin = reader
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		err = pluginError("put", err, stderr.String())
+		log.Error("failed", "error", err)
+		return err
+	}
+	log.Trace("complete")
+	return nil
+}
+
+func (cache *execCache) String() string {
+	return "exec"
+}
+
+// pluginError describes a failed plugin operation using the error reported by the plugin if any
+func pluginError(operation string, err error, stderr string) error {
+	stderr = strings.TrimSpace(stderr)
+	if i := strings.LastIndexByte(stderr, '\n'); i >= 0 {
+		stderr = stderr[i+1:]
+	}
+	var status execStatus
+	if json.Unmarshal([]byte(stderr), &status) == nil && status.Error != "" {
+		return fmt.Errorf("plugin %s failed: %s", operation, status.Error)
+	}
+	if stderr != "" {
+		return fmt.Errorf("plugin %s failed: %v: %s", operation, err, stderr)
+	}
+	return fmt.Errorf("plugin %s failed: %v", operation, err)
+}
+
+// execReader reads the output of a plugin get, reporting a failure of the plugin
+// once its output has been read
+type execReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+	cancel context.CancelFunc
+
+	once    sync.Once
+	waitErr error
+}
+
+func (r *execReader) Read(p []byte) (int, error) {
+	n, err := r.stdout.Read(p)
+	if err == io.EOF {
+		if waitErr := r.wait(); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// Close stops the plugin if it is still running
+func (r *execReader) Close() error {
+	r.cancel()
+	r.wait()
+	return nil
+}
+
+func (r *execReader) wait() error {
+	r.once.Do(func() {
+		if err := r.cmd.Wait(); err != nil {
+			r.waitErr = pluginError("get", err, r.stderr.String())
+		}
+		r.cancel()
+	})
+	return r.waitErr
+}
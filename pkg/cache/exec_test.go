@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// testPlugin stores entries as files in the directory given as its first argument
+const testPlugin = `#!/bin/sh
+dir="$1"; operation="$2"; key="$3"
+case "$operation" in
+has)
+	if [ -f "$dir/$key" ]; then echo '{"found": true}'; else echo '{"found": false}'; fi ;;
+get)
+	if [ ! -f "$dir/$key" ]; then echo '{"error": "no such entry"}' >&2; exit 1; fi
+	cat "$dir/$key" ;;
+put)
+	if [ "$key" = "readonly" ]; then echo "starting" >&2; echo '{"error": "read-only key"}' >&2; exit 1; fi
+	cat > "$dir/$key" ;;
+*)
+	exit 2 ;;
+esac
+`
+
+func TestExecCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	plugin := filepath.Join(t.TempDir(), "npmi-cache-test")
+	if err := os.WriteFile(plugin, []byte(testPlugin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := NewExecCache(plugin, []string{dir}, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found, err := cache.Has(ctx, "a"); err != nil || found {
+		t.Errorf("Has() = %v, %v, want not found", found, err)
+	}
+	if err := cache.Put(ctx, "a", strings.NewReader("hello")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if found, err := cache.Has(ctx, "a"); err != nil || !found {
+		t.Errorf("Has() = %v, %v, want found", found, err)
+	}
+
+	reader, err := cache.Get(ctx, "a")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.(io.Closer).Close()
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Get() = %q, %v", data, err)
+	}
+
+	reader, err = cache.Get(ctx, "missing")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	_, err = io.ReadAll(reader)
+	reader.(io.Closer).Close()
+	if err == nil || !strings.Contains(err.Error(), "no such entry") {
+		t.Errorf("Was expecting the error reported by the plugin, got %v", err)
+	}
+
+	err = cache.Put(ctx, "readonly", strings.NewReader("hello"))
+	if err == nil || !strings.Contains(err.Error(), "read-only key") {
+		t.Errorf("Was expecting the error reported by the plugin, got %v", err)
+	}
+
+	if _, err := NewExecCache(filepath.Join(dir, "missing"), nil, hclog.NewNullLogger()); err == nil {
+		t.Errorf("Was expecting a missing plugin to be rejected")
+	}
+}
+
+func TestExecCacheCancelled(t *testing.T) {
+	// The plugin hangs in a child process holding its output open
+	plugin := filepath.Join(t.TempDir(), "npmi-cache-test")
+	if err := os.WriteFile(plugin, []byte("#!/bin/sh\nsleep 60 &\nwait\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := NewExecCache(plugin, nil, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := cache.Has(ctx, "a"); err == nil {
+		t.Errorf("Was expecting a cancelled plugin to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Has() returned after %s, was expecting the plugin and its children to be terminated", elapsed)
+	}
+}
@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// httpCache is a client of a cache served by npmi-go serve
+type httpCache struct {
+	baseURL string
+	token   string
+	client  *http.Client
+	log     hclog.Logger
+}
+
+// NewHTTPCache creates a Cacher, which stores data in a cache served over HTTP at baseURL
+// by npmi-go serve. If token is set, it is sent as a bearer token.
+func NewHTTPCache(baseURL string, token string, log hclog.Logger) (Cacher, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("no cache URL given")
+	}
+	if _, err := url.ParseRequestURI(baseURL); err != nil {
+		return nil, fmt.Errorf("invalid cache URL '%s': %v", baseURL, err)
+	}
+	return &httpCache{strings.TrimSuffix(baseURL, "/"), token, &http.Client{}, log}, nil
+}
+
+func (cache *httpCache) newRequest(ctx context.Context, method string, key string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, cache.baseURL+"/cache/"+escapeKey(key), body)
+	if err != nil {
+		return nil, err
+	}
+	if cache.token != "" {
+		req.Header.Set("Authorization", "Bearer "+cache.token)
+	}
+	return req, nil
+}
+
+// Has determines whether the server has a given key
+func (cache *httpCache) Has(ctx context.Context, key string) (bool, error) {
+	log := cache.log.Named("has")
+	log.Trace("start", "key", key)
+
+	req, err := cache.newRequest(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := cache.client.Do(req)
+	if err != nil {
+		log.Error("failed", "error", err)
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		log.Trace("complete", "found", true)
+		return true, nil
+	case http.StatusNotFound:
+		log.Trace("complete", "found", false)
+		return false, nil
+	default:
+		err := fmt.Errorf("unexpected response: %s", resp.Status)
+		log.Error("failed", "error", err)
+		return false, err
+	}
+}
+
+// Get fetches something from the server. The returned reader must be closed.
+func (cache *httpCache) Get(ctx context.Context, key string) (io.Reader, error) {
+	log := cache.log.Named("get")
+	log.Trace("start", "key", key)
+
+	req, err := cache.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cache.client.Do(req)
+	if err != nil {
+		log.Error("failed", "error", err)
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err := fmt.Errorf("unexpected response: %s", resp.Status)
+		log.Error("failed", "error", err)
+		return nil, err
+	}
+	log.Trace("complete", "size", resp.ContentLength)
+	return resp.Body, nil
+}
+
+// Put stores something on the server
+func (cache *httpCache) Put(ctx context.Context, key string, reader io.Reader) error {
+	log := cache.log.Named("put")
+	log.Trace("start", "key", key)
+
+	req, err := cache.newRequest(ctx, http.MethodPut, key, io.NopCloser(reader))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	// Let the server reject entries which are too large before they are uploaded
+	if size, ok := remainingSize(reader); ok {
+		req.ContentLength = size
+	}
+
+	resp, err := cache.client.Do(req)
+	if err != nil {
+		log.Error("failed", "error", err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("unexpected response: %s", resp.Status)
+		log.Error("failed", "error", err)
+		return err
+	}
+	log.Trace("complete")
+	return nil
+}
+
+func (cache *httpCache) String() string {
+	return "http"
+}
+
+// remainingSize returns the number of bytes left in a seekable reader
+func remainingSize(reader io.Reader) (int64, bool) {
+	seeker, ok := reader.(io.Seeker)
+	if !ok {
+		return 0, false
+	}
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	if _, err := seeker.Seek(current, io.SeekStart); err != nil {
+		return 0, false
+	}
+	return end - current, true
+}
+
+// escapeKey escapes the segments of a key, which may be prefixed with a namespace, for use in a URL path
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
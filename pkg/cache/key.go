@@ -0,0 +1,204 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxKeyLength limits the length of a key, which is used in file names by the local cache
+// and for temporary archives. The longest of these, modules-<key>.tar.gz, must still fit
+// in the 255 byte file name limit of common file systems.
+const maxKeyLength = 255 - len("modules-.tar.gz")
+
+// lockSuffix is appended to a key to name its lock, so keys must not end with it
+const lockSuffix = ".lock"
+
+// ValidateKey checks that a key is safe to use in every cache. A key consists of letters,
+// digits, dots, dashes and underscores, starts with a letter or a digit and is at most
+// 240 characters long.
+func ValidateKey(key string) error {
+	if err := validateSegment(key); err != nil {
+		return fmt.Errorf("invalid cache key %q: %v", key, err)
+	}
+	if strings.HasSuffix(key, lockSuffix) {
+		return fmt.Errorf("invalid cache key %q: must not end with %s", key, lockSuffix)
+	}
+	return nil
+}
+
+// ValidateNamespace checks that a namespace such as team-a/myapp consists of one or more
+// slash-separated segments following the rules of keys
+func ValidateNamespace(namespace string) error {
+	for _, segment := range strings.Split(namespace, "/") {
+		if err := validateSegment(segment); err != nil {
+			return fmt.Errorf("invalid namespace %q: %v", namespace, err)
+		}
+	}
+	return nil
+}
+
+// validateNamespacedKey checks a key which may be prefixed with a namespace
+func validateNamespacedKey(key string) error {
+	namespace, key, found := cutLast(key, "/")
+	if found {
+		if err := ValidateNamespace(namespace); err != nil {
+			return err
+		}
+	}
+	return ValidateKey(key)
+}
+
+func validateSegment(segment string) error {
+	switch {
+	case segment == "":
+		return fmt.Errorf("must not be empty")
+	case len(segment) > maxKeyLength:
+		return fmt.Errorf("longer than %d characters", maxKeyLength)
+	case !isAlphanumeric(rune(segment[0])):
+		return fmt.Errorf("must start with a letter or a digit")
+	case strings.Contains(segment, ".."):
+		return fmt.Errorf("must not contain ..")
+	}
+	for _, r := range segment {
+		if !isAlphanumeric(r) && r != '.' && r != '-' && r != '_' {
+			return fmt.Errorf("invalid character %q", r)
+		}
+	}
+	return nil
+}
+
+func isAlphanumeric(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
+
+func cutLast(s string, sep string) (before string, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return "", s, false
+}
+
+// namespacedCache validates keys and stores them under a namespace in another cache
+type namespacedCache struct {
+	cache  Cacher
+	prefix string
+}
+
+// NewNamespacedCache wraps a cache so that keys are validated and stored as
+// <namespace>/<key>. An empty namespace only validates keys.
+func NewNamespacedCache(cache Cacher, namespace string) (Cacher, error) {
+	prefix := ""
+	if namespace != "" {
+		if err := ValidateNamespace(namespace); err != nil {
+			return nil, err
+		}
+		prefix = namespace + "/"
+	}
+	return &namespacedCache{cache, prefix}, nil
+}
+
+func (c *namespacedCache) key(key string) (string, error) {
+	if err := ValidateKey(key); err != nil {
+		return "", err
+	}
+	return c.prefix + key, nil
+}
+
+// Has determines whether the cache contains a given key
+func (c *namespacedCache) Has(ctx context.Context, key string) (bool, error) {
+	key, err := c.key(key)
+	if err != nil {
+		return false, err
+	}
+	return c.cache.Has(ctx, key)
+}
+
+// Get fetches something from the cache
+func (c *namespacedCache) Get(ctx context.Context, key string) (io.Reader, error) {
+	key, err := c.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return c.cache.Get(ctx, key)
+}
+
+// Put stores something in the cache
+func (c *namespacedCache) Put(ctx context.Context, key string, reader io.Reader) error {
+	key, err := c.key(key)
+	if err != nil {
+		return err
+	}
+	return c.cache.Put(ctx, key, reader)
+}
+
+// PutWithMetadata stores something in the cache along with metadata if the cache supports it
+func (c *namespacedCache) PutWithMetadata(ctx context.Context, key string, reader io.Reader, metadata Metadata) error {
+	key, err := c.key(key)
+	if err != nil {
+		return err
+	}
+	return PutWithMetadata(ctx, c.cache, key, reader, metadata)
+}
+
+// Stat describes an entry without the namespace. Caches which can't describe their
+// entries only report whether the key exists.
+func (c *namespacedCache) Stat(ctx context.Context, key string) (*Entry, error) {
+	namespacedKey, err := c.key(key)
+	if err != nil {
+		return nil, err
+	}
+	if stater, ok := c.cache.(Stater); ok {
+		entry, err := stater.Stat(ctx, namespacedKey)
+		if entry != nil {
+			entry.Key = key
+		}
+		return entry, err
+	}
+	found, err := c.cache.Has(ctx, namespacedKey)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &Entry{Key: key}, nil
+}
+
+// TryLock takes the build lock of a key if the cache supports locking
+func (c *namespacedCache) TryLock(ctx context.Context, key string) (unlock func() error, ok bool, err error) {
+	key, err = c.key(key)
+	if err != nil {
+		return nil, false, err
+	}
+	locker, isLocker := c.cache.(Locker)
+	if !isLocker {
+		return func() error { return nil }, true, nil
+	}
+	return locker.TryLock(ctx, key)
+}
+
+// List returns the entries of the namespace whose keys start with prefix, without the namespace
+func (c *namespacedCache) List(ctx context.Context, prefix string) ([]Entry, error) {
+	lister, ok := c.cache.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("%s cache can't list its entries", c.cache)
+	}
+	entries, err := lister.List(ctx, c.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := entries[:0]
+	for _, entry := range entries {
+		entry.Key = strings.TrimPrefix(entry.Key, c.prefix)
+		// Skip entries of nested namespaces
+		if strings.Contains(entry.Key, "/") {
+			continue
+		}
+		listed = append(listed, entry)
+	}
+	return listed, nil
+}
+
+func (c *namespacedCache) String() string {
+	return fmt.Sprint(c.cache)
+}
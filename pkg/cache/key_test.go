@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		wantErr bool
+	}{
+		{"v20.10.0-linux-x64-glibc2.36-abi115-dev-3145195a3ac8", false},
+		{"v20.10.0-linux-x64-debian12_1-prod-abc", false},
+		{"", true},
+		{"../escape", true},
+		{"a/b", true},
+		{`a\b`, true},
+		{".hidden", true},
+		{"-flag", true},
+		{"a..b", true},
+		{"key.lock", true},
+		{"key with space", true},
+		{strings.Repeat("a", maxKeyLength), false},
+		{strings.Repeat("a", maxKeyLength+1), true},
+	}
+	for _, tt := range tests {
+		if err := ValidateKey(tt.key); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateKey(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateNamespace(t *testing.T) {
+	tests := []struct {
+		namespace string
+		wantErr   bool
+	}{
+		{"team-a", false},
+		{"team-a/myapp", false},
+		{"", true},
+		{"/team-a", true},
+		{"team-a/", true},
+		{"team-a//myapp", true},
+		{"team-a/../b", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateNamespace(tt.namespace); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateNamespace(%q) error = %v, wantErr %v", tt.namespace, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNamespacedCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	local, err := NewLocalCache(dir, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewNamespacedCache(local, "team-a/../b"); err == nil {
+		t.Errorf("Was expecting an invalid namespace to be rejected")
+	}
+
+	teamA, err := NewNamespacedCache(local, "team-a/myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	teamB, err := NewNamespacedCache(local, "team-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := teamA.Put(ctx, "v20-abc", strings.NewReader("a")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "team-a", "myapp", "v20-abc")); err != nil {
+		t.Errorf("Entry not stored in the namespace: %v", err)
+	}
+	if found, err := teamB.Has(ctx, "v20-abc"); err != nil || found {
+		t.Errorf("Has() in another namespace = %v, %v, want not found", found, err)
+	}
+	if err := teamA.Put(ctx, "../../escape", strings.NewReader("a")); err == nil {
+		t.Errorf("Was expecting an invalid key to be rejected")
+	}
+
+	entries, err := teamA.(Lister).List(ctx, "v20")
+	if err != nil || len(entries) != 1 || entries[0].Key != "v20-abc" {
+		t.Errorf("List() = %+v, %v, want v20-abc", entries, err)
+	}
+	entries, err = local.(Lister).List(ctx, "team-a/")
+	if err != nil || len(entries) != 1 || entries[0].Key != "team-a/myapp/v20-abc" {
+		t.Errorf("List() without namespace = %+v, %v, want team-a/myapp/v20-abc", entries, err)
+	}
+
+	entry, err := teamA.(Stater).Stat(ctx, "v20-abc")
+	if err != nil || entry == nil || entry.Key != "v20-abc" || entry.Size != 1 {
+		t.Errorf("Stat() = %+v, %v, want v20-abc of 1 byte", entry, err)
+	}
+	if entry, err := teamB.(Stater).Stat(ctx, "v20-abc"); err != nil || entry != nil {
+		t.Errorf("Stat() in another namespace = %+v, %v, want nil", entry, err)
+	}
+
+	// Caches without metadata store the entry only
+	if err := PutWithMetadata(ctx, teamB, "v20-def", strings.NewReader("b"), Metadata{"project": "b"}); err != nil {
+		t.Errorf("PutWithMetadata() error = %v", err)
+	}
+	if found, err := teamB.Has(ctx, "v20-def"); err != nil || !found {
+		t.Errorf("Has() after PutWithMetadata() = %v, %v, want found", found, err)
+	}
+
+	// Caches without locking are never locked
+	unlock, ok, err := teamA.(Locker).TryLock(ctx, "v20-abc")
+	if err != nil || !ok || unlock() != nil {
+		t.Errorf("TryLock() = %v, %v", ok, err)
+	}
+}
+
+func TestLocalCacheLongestKey(t *testing.T) {
+	ctx := context.Background()
+	local, err := NewLocalCache(t.TempDir(), hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := NewNamespacedCache(local, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Put takes a lock named after the key, which must fit as well
+	key := strings.Repeat("a", maxKeyLength)
+	if err := cache.Put(ctx, key, strings.NewReader("a")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if found, err := cache.Has(ctx, key); err != nil || !found {
+		t.Errorf("Has() = %v, %v, want found", found, err)
+	}
+	if err := cache.Put(ctx, key+"a", strings.NewReader("a")); err == nil {
+		t.Errorf("Was expecting a key longer than %d characters to be rejected", maxKeyLength)
+	}
+}
@@ -1,13 +1,18 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hermo/npmi-go/pkg/files"
+	"github.com/hermo/npmi-go/pkg/lock"
 )
 
 type localCache struct {
@@ -26,48 +31,175 @@ func NewLocalCache(dir string, log hclog.Logger) (Cacher, error) {
 	return &localCache{dir, log}, nil
 }
 
-func (cache *localCache) joinPath(key string) string {
-	// TODO: Validate key format
-	return path.Join(cache.dir, key)
+// joinPath returns the path of a key, which may be prefixed with a namespace.
+// The key is validated so that it can't point outside of the cache directory.
+func (cache *localCache) joinPath(key string) (string, error) {
+	if err := validateNamespacedKey(key); err != nil {
+		return "", err
+	}
+	return path.Join(cache.dir, key), nil
 }
 
 // Has indicates whether a LocalCache contains a given key or not
-func (cache *localCache) Has(key string) (bool, error) {
+func (cache *localCache) Has(ctx context.Context, key string) (bool, error) {
 	log := cache.log.Named("has")
-	path := cache.joinPath(key)
+	path, err := cache.joinPath(key)
+	if err != nil {
+		return false, err
+	}
 	log.Trace("start", "key", key, "path", path)
 	return files.IsExistingFile(path)
 }
 
+// Stat describes an entry, or returns nil if the cache doesn't contain the key.
+// The local cache stores no metadata.
+func (cache *localCache) Stat(ctx context.Context, key string) (*Entry, error) {
+	log := cache.log.Named("stat")
+	path, err := cache.joinPath(key)
+	if err != nil {
+		return nil, err
+	}
+	log.Trace("start", "key", key, "path", path)
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Entry{Key: key, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
 // Get fetches something from the cache
-func (cache *localCache) Get(key string) (io.Reader, error) {
+func (cache *localCache) Get(ctx context.Context, key string) (io.Reader, error) {
 	log := cache.log.Named("get")
-	path := cache.joinPath(key)
+	path, err := cache.joinPath(key)
+	if err != nil {
+		return nil, err
+	}
 	log.Trace("start", "key", key, "path", path)
 	return os.Open(path)
 }
 
-// Put stores something in the cache
-func (cache *localCache) Put(key string, reader io.Reader) error {
+// Put stores something in the cache.
+// Data is written to a temporary file first so that an interrupted Put never leaves
+// a partial entry behind. Concurrent writes of the same key are serialized.
+func (cache *localCache) Put(ctx context.Context, key string, reader io.Reader) error {
 	log := cache.log.Named("put")
-	path := cache.joinPath(key)
+	path, err := cache.joinPath(key)
+	if err != nil {
+		return err
+	}
 	log.Trace("start", "key", key, "path", path)
-	f, err := os.Create(path)
+
+	// Namespaces are stored as subdirectories
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Error("mkdir failed", "error", err)
+		return err
+	}
+	keyLock, err := lock.Acquire(ctx, path+lockSuffix)
+	if err != nil {
+		log.Error("lock failed", "error", err)
+		return err
+	}
+	defer keyLock.Release()
+	f, err := os.CreateTemp(cache.dir, ".tmp-*")
 	if err != nil {
 		log.Error("create failed", "error", err)
-		return nil
+		return err
 	}
+	defer os.Remove(f.Name())
 
-	defer f.Close()
-	_, err = io.Copy(f, reader)
+	_, err = io.Copy(f, files.NewContextReader(ctx, reader))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
 		log.Error("copy failed", "error", err)
 		return err
 	}
+
+	// CreateTemp uses mode 0600, make the entry readable like any other created file
+	if err = os.Chmod(f.Name(), 0644); err != nil {
+		log.Error("chmod failed", "error", err)
+		return err
+	}
+
+	if err = os.Rename(f.Name(), path); err != nil {
+		log.Error("rename failed", "error", err)
+		return err
+	}
 	log.Trace("complete")
 	return nil
 }
 
+// List returns the entries of the cache directory whose keys start with prefix.
+// Entries in namespaces are listed with the namespace as part of the key. Files not
+// named like keys and directories which can't be read are skipped.
+func (cache *localCache) List(ctx context.Context, prefix string) ([]Entry, error) {
+	log := cache.log.Named("list")
+	log.Trace("start", "prefix", prefix)
+
+	// Only walk the namespace the prefix points to
+	root := cache.dir
+	if namespace, _, found := cutLast(prefix, "/"); found {
+		if err := ValidateNamespace(namespace); err != nil {
+			return nil, err
+		}
+		root = filepath.Join(cache.dir, filepath.FromSlash(namespace))
+	}
+
+	var entries []Entry
+	err := filepath.WalkDir(root, func(file string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if os.IsPermission(err) && file != root {
+				log.Debug("Skipping unreadable directory", "path", file, "error", err)
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := dirEntry.Name()
+		// Skip temporary files of Puts in progress and lock files
+		if !dirEntry.Type().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, lockSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(cache.dir, file)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		if err := validateNamespacedKey(key); err != nil {
+			log.Debug("Skipping file not named like a key", "path", key)
+			return nil
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		entries = append(entries, Entry{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		log.Error("failed", "error", err)
+		return nil, err
+	}
+
+	log.Trace("complete", "entries", len(entries))
+	return entries, nil
+}
+
 func (cache *localCache) String() string {
 	return "local"
 }
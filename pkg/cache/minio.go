@@ -2,9 +2,22 @@ package cache
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"maps"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/minio/minio-go/v7"
@@ -20,12 +33,25 @@ type minioCache struct {
 	useTLS          bool
 	insecureTLS     bool
 	bucket          string
+	retry           RetryPolicy
+	lockTTL         time.Duration
 	log             hclog.Logger
 }
 
 // NewMinioCache creates a new Minio Cache
 func NewMinioCache(endpoint string, accessKeyID string, secretAccessKey string, bucket string, useTLS bool, insecureTLS bool, log hclog.Logger) *minioCache {
-	return &minioCache{nil, endpoint, accessKeyID, secretAccessKey, useTLS, insecureTLS, bucket, log}
+	return &minioCache{nil, endpoint, accessKeyID, secretAccessKey, useTLS, insecureTLS, bucket, DefaultRetryPolicy(), 0, log}
+}
+
+// SetRetryPolicy sets the timeouts and retry behaviour of Minio operations
+func (cache *minioCache) SetRetryPolicy(policy RetryPolicy) {
+	cache.retry = policy
+}
+
+// SetLockTTL enables build locks for keys. A lock older than ttl is considered
+// abandoned. Zero disables locking.
+func (cache *minioCache) SetLockTTL(ttl time.Duration) {
+	cache.lockTTL = ttl
 }
 
 // Dial connects to a Minio instance
@@ -54,33 +80,104 @@ func (cache *minioCache) Dial() error {
 }
 
 // Has determines whether or not Minio contains a given key
-func (cache *minioCache) Has(key string) (bool, error) {
+func (cache *minioCache) Has(ctx context.Context, key string) (bool, error) {
 	log := cache.log.Named("has")
 	log.Trace("start", "key", key)
-	_, err := cache.client.StatObject(context.Background(), cache.bucket, key, minio.StatObjectOptions{})
+
+	entry, err := cache.stat(ctx, key, log)
 	if err != nil {
-		// Handle NoSuchKey error from Minio
-		if serr, ok := err.(minio.ErrorResponse); ok {
-			if serr.Code == "NoSuchKey" {
-				log.Trace("complete", "found", false)
-				return false, nil
+		return false, err
+	}
+	log.Trace("complete", "found", entry != nil)
+	return entry != nil, nil
+}
+
+// Stat describes an object including its user metadata, or returns nil if Minio doesn't contain the key
+func (cache *minioCache) Stat(ctx context.Context, key string) (*Entry, error) {
+	log := cache.log.Named("stat")
+	log.Trace("start", "key", key)
+
+	entry, err := cache.stat(ctx, key, log)
+	if err != nil {
+		return nil, err
+	}
+	log.Trace("complete", "found", entry != nil)
+	return entry, nil
+}
+
+func (cache *minioCache) stat(ctx context.Context, key string, log hclog.Logger) (*Entry, error) {
+	ctx, cancel := cache.retry.withDeadline(ctx)
+	defer cancel()
+
+	var entry *Entry
+	err := retry(ctx, cache.retry, log, isRetryableMinioError, func(ctx context.Context) error {
+		info, err := cache.client.StatObject(ctx, cache.bucket, key, minio.StatObjectOptions{})
+		if err != nil {
+			// Handle NoSuchKey error from Minio
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				entry = nil
+				return nil
 			}
+			return err
 		}
+		entry = &Entry{Key: key, Size: info.Size, ModTime: info.LastModified, Metadata: objectMetadata(info.UserMetadata)}
+		return nil
+	})
+	if err != nil {
 		log.Error("failed", "error", err)
-		return false, err
+		return nil, err
 	}
-	log.Trace("complete", "found", true)
-	return true, nil
+	return entry, nil
 }
 
-// Put stores something in the cache
+// Put stores something in the cache.
+// Failed uploads are retried only if reader is also an io.Seeker.
 // TODO: Test with inputs larger than 128 MiB
-func (cache *minioCache) Put(key string, reader io.Reader) error {
+func (cache *minioCache) Put(ctx context.Context, key string, reader io.Reader) error {
+	return cache.PutWithMetadata(ctx, key, reader, nil)
+}
+
+// PutWithMetadata stores something in the cache. The metadata is stored both as user metadata
+// and, for use in bucket lifecycle rules, as object tags.
+func (cache *minioCache) PutWithMetadata(ctx context.Context, key string, reader io.Reader, metadata Metadata) error {
 	log := cache.log.Named("put")
 	log.Trace("start", "key", key)
-	_, err := cache.client.PutObject(
-		context.Background(), cache.bucket, key, reader, -1,
-		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+
+	ctx, cancel := cache.retry.withDeadline(ctx)
+	defer cancel()
+
+	policy := cache.retry
+	// Uploading a large archive may take longer than a single operation is allowed to
+	policy.OperationTimeout = 0
+	seeker, canRewind := reader.(io.Seeker)
+	var startOffset int64
+	if canRewind {
+		var err error
+		if startOffset, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			canRewind = false
+		}
+	}
+	if !canRewind {
+		policy.MaxAttempts = 1
+	}
+
+	attempt := 0
+	err := retry(ctx, policy, log, isRetryableMinioError, func(ctx context.Context) error {
+		attempt++
+		if attempt > 1 {
+			if _, err := seeker.Seek(startOffset, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		_, err := cache.client.PutObject(
+			ctx, cache.bucket, key, reader, -1,
+			minio.PutObjectOptions{
+				ContentType:  "application/octet-stream",
+				UserMetadata: userMetadata(metadata),
+				UserTags:     objectTags(metadata),
+			})
+		return err
+	})
 
 	if err != nil {
 		log.Error("failed", "error", err)
@@ -90,13 +187,436 @@ func (cache *minioCache) Put(key string, reader io.Reader) error {
 	return nil
 }
 
-// Get fetches something from the cache
-func (cache *minioCache) Get(key string) (io.Reader, error) {
-	log := cache.log.Named("put")
+// Get fetches something from the cache.
+// Interrupted downloads are resumed from the last received byte.
+func (cache *minioCache) Get(ctx context.Context, key string) (io.Reader, error) {
+	log := cache.log.Named("get")
 	log.Trace("start", "key", key)
-	return cache.client.GetObject(context.Background(), cache.bucket, key, minio.GetObjectOptions{})
+
+	ctx, cancel := cache.retry.withDeadline(ctx)
+
+	var info minio.ObjectInfo
+	err := retry(ctx, cache.retry, log, isRetryableMinioError, func(ctx context.Context) error {
+		var err error
+		info, err = cache.client.StatObject(ctx, cache.bucket, key, minio.StatObjectOptions{})
+		return err
+	})
+	if err != nil {
+		cancel()
+		log.Error("failed", "error", err)
+		return nil, err
+	}
+
+	return &minioObjectReader{
+		ctx:    ctx,
+		cancel: cancel,
+		cache:  cache,
+		key:    key,
+		etag:   info.ETag,
+		size:   info.Size,
+		log:    log,
+	}, nil
+}
+
+// TryLock takes the build lock of a key by creating a lock object next to it.
+// The object is created conditionally so that only one client can hold the lock.
+// It contains a token unique to the holder, so that a lock taken over by another
+// client after expiring is not removed by unlock.
+func (cache *minioCache) TryLock(ctx context.Context, key string) (unlock func() error, ok bool, err error) {
+	noop := func() error { return nil }
+	if cache.lockTTL <= 0 {
+		return noop, true, nil
+	}
+
+	log := cache.log.Named("lock")
+	lockKey := key + lockSuffix
+	log.Trace("start", "key", lockKey)
+
+	owner, err := newLockOwner()
+	if err != nil {
+		return noop, false, err
+	}
+	unlock = func() error {
+		return cache.unlock(lockKey, owner, log)
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		err = cache.putLock(ctx, lockKey, owner, "")
+		if err == nil {
+			log.Trace("complete", "acquired", true, "owner", owner)
+			return unlock, true, nil
+		}
+		if minio.ToErrorResponse(err).Code != "PreconditionFailed" {
+			log.Error("failed", "error", err)
+			return noop, false, err
+		}
+
+		info, err := cache.client.StatObject(ctx, cache.bucket, lockKey, minio.StatObjectOptions{})
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				// Released between our attempt and the stat, try again
+				continue
+			}
+			log.Error("failed", "error", err)
+			return noop, false, err
+		}
+
+		age := time.Since(info.LastModified)
+		if age < cache.lockTTL {
+			log.Trace("complete", "acquired", false, "age", age)
+			return noop, false, nil
+		}
+
+		// Replace the expired lock only if it is still the one we saw, so that
+		// of several clients finding it expired only one takes it over
+		log.Warn("taking over expired lock", "key", lockKey, "age", age)
+		err = cache.putLock(ctx, lockKey, owner, info.ETag)
+		if err == nil {
+			log.Trace("complete", "acquired", true, "owner", owner)
+			return unlock, true, nil
+		}
+		code := minio.ToErrorResponse(err).Code
+		if code != "PreconditionFailed" && code != "NoSuchKey" {
+			log.Error("failed", "error", err)
+			return noop, false, err
+		}
+	}
+
+	log.Trace("complete", "acquired", false)
+	return noop, false, nil
+}
+
+// putLock creates a lock object containing owner. If etag is empty, the object must not
+// exist. Otherwise the object must have the given ETag.
+func (cache *minioCache) putLock(ctx context.Context, lockKey string, owner string, etag string) error {
+	opts := minio.PutObjectOptions{ContentType: "text/plain"}
+	if etag == "" {
+		opts.SetMatchETagExcept("*")
+	} else {
+		opts.SetMatchETag(etag)
+	}
+	_, err := cache.client.PutObject(ctx, cache.bucket, lockKey, strings.NewReader(owner), int64(len(owner)), opts)
+	return err
+}
+
+// unlock removes a lock object unless it has been taken over by another client. S3 has
+// no conditional deletes, so a lock taken over between the check and the removal is
+// still removed. The lock is released even if the context of the build has been
+// cancelled, within the limits of the retry policy.
+func (cache *minioCache) unlock(lockKey string, owner string, log hclog.Logger) error {
+	ctx, cancel := cache.retry.withDeadline(context.Background())
+	defer cancel()
+
+	return retry(ctx, cache.retry, log, isRetryableMinioError, func(ctx context.Context) error {
+		object, err := cache.client.GetObject(ctx, cache.bucket, lockKey, minio.GetObjectOptions{})
+		if err != nil {
+			return err
+		}
+		defer object.Close()
+		holder, err := io.ReadAll(io.LimitReader(object, maxLockOwnerLength))
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return nil
+			}
+			return err
+		}
+		if string(holder) != owner {
+			log.Warn("lock taken over by another client, not removing it", "key", lockKey, "owner", string(holder))
+			return nil
+		}
+		return cache.client.RemoveObject(ctx, cache.bucket, lockKey, minio.RemoveObjectOptions{})
+	})
+}
+
+// maxLockOwnerLength limits the size of a lock object read when unlocking
+const maxLockOwnerLength = 1024
+
+// newLockOwner creates a token identifying the holder of a lock, which is unique
+// also between locks taken in the same process
+func newLockOwner() (string, error) {
+	hostname, _ := os.Hostname()
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d:%s", hostname, os.Getpid(), hex.EncodeToString(random)), nil
+}
+
+// List returns the objects of the bucket whose keys start with prefix
+func (cache *minioCache) List(ctx context.Context, prefix string) ([]Entry, error) {
+	log := cache.log.Named("list")
+	log.Trace("start", "prefix", prefix)
+
+	ctx, cancel := cache.retry.withDeadline(ctx)
+	defer cancel()
+
+	var entries []Entry
+	err := retry(ctx, cache.retry, log, isRetryableMinioError, func(ctx context.Context) error {
+		entries = nil
+		for object := range cache.client.ListObjects(ctx, cache.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+			if object.Err != nil {
+				return object.Err
+			}
+			if strings.HasSuffix(object.Key, lockSuffix) {
+				continue
+			}
+			entries = append(entries, Entry{Key: object.Key, Size: object.Size, ModTime: object.LastModified})
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("failed", "error", err)
+		return nil, err
+	}
+	log.Trace("complete", "entries", len(entries))
+	return entries, nil
 }
 
 func (cache *minioCache) String() string {
 	return "minio"
 }
+
+// Limits of S3 object tags
+const (
+	maxObjectTags     = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// userMetadata returns metadata with values restricted to printable ASCII as required of HTTP headers
+func userMetadata(metadata Metadata) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	headers := make(map[string]string, len(metadata))
+	for name, value := range metadata {
+		headers[name] = strings.Map(func(r rune) rune {
+			if r < ' ' || r > '~' {
+				return '?'
+			}
+			return r
+		}, value)
+	}
+	return headers
+}
+
+// objectTags returns metadata as object tags. Minio silently drops tags which are invalid,
+// so unsupported characters are replaced, values are truncated and at most 10 tags are
+// returned in the order of their names.
+func objectTags(metadata Metadata) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	names := slices.Sorted(maps.Keys(metadata))
+	tags := make(map[string]string, min(len(names), maxObjectTags))
+	for _, name := range names[:min(len(names), maxObjectTags)] {
+		tags[truncate(tagValue(name), maxTagKeyLength)] = truncate(tagValue(metadata[name]), maxTagValueLength)
+	}
+	return tags
+}
+
+// tagValue replaces the characters not allowed in object tags
+func tagValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("+-=._:/@ ", r) {
+			return r
+		}
+		return '_'
+	}, value)
+}
+
+func truncate(s string, length int) string {
+	if len(s) > length {
+		return s[:length]
+	}
+	return s
+}
+
+// objectMetadata returns the user metadata of an object using lowercase names
+func objectMetadata(userMetadata map[string]string) Metadata {
+	if len(userMetadata) == 0 {
+		return nil
+	}
+	metadata := make(Metadata, len(userMetadata))
+	for name, value := range userMetadata {
+		metadata[strings.ToLower(name)] = value
+	}
+	return metadata
+}
+
+// isRetryableMinioError determines whether a failed Minio operation may succeed when retried
+func isRetryableMinioError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	resp := minio.ToErrorResponse(err)
+	switch {
+	case resp.StatusCode == 0:
+		// Not a response from the server. Only network errors are transient,
+		// e.g. invalid arguments or TLS certificate errors are not.
+		return isNetworkError(err)
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return true
+	case resp.StatusCode >= 500:
+		return true
+	default:
+		return false
+	}
+}
+
+// isNetworkError determines whether err is caused by a failed connection or a timeout
+func isNetworkError(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF)
+}
+
+// minioObjectReader reads an object from Minio and transparently resumes
+// the download from the current offset if the connection fails
+type minioObjectReader struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	cache  *minioCache
+	key    string
+	etag   string
+	size   int64
+	log    hclog.Logger
+
+	mu           sync.Mutex
+	object       *minio.Object
+	objectCtx    context.Context
+	objectCancel context.CancelFunc
+	watchdog     *time.Timer
+	offset       int64
+	failures     int
+}
+
+func (r *minioObjectReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for {
+		if r.offset >= r.size {
+			r.closeObject()
+			r.cancel()
+			return 0, io.EOF
+		}
+
+		if r.object == nil {
+			if err := r.openObject(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := r.object.Read(p)
+		r.offset += int64(n)
+		if n > 0 {
+			r.failures = 0
+			r.resetWatchdog()
+		}
+
+		if err == nil || (err == io.EOF && r.offset >= r.size) {
+			return n, err
+		}
+
+		timedOut := r.objectCtx.Err() != nil && r.ctx.Err() == nil
+		r.closeObject()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		if n > 0 {
+			// Hand out what was received, the next Read resumes from the new offset
+			return n, nil
+		}
+
+		r.failures++
+		if r.ctx.Err() != nil || r.failures >= r.cache.retry.MaxAttempts || !(timedOut || isRetryableMinioError(err)) {
+			r.cancel()
+			r.log.Error("download failed", "key", r.key, "offset", r.offset, "size", r.size, "error", err)
+			return 0, fmt.Errorf("download of %s failed at offset %d: %w", r.key, r.offset, err)
+		}
+
+		delay := r.cache.retry.backoff(r.failures)
+		r.log.Warn("download interrupted, resuming", "key", r.key, "offset", r.offset, "size", r.size, "delay", delay, "error", err)
+		if !sleep(r.ctx, delay) {
+			return 0, r.ctx.Err()
+		}
+	}
+}
+
+// Close releases the resources associated with the download
+func (r *minioObjectReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closeObject()
+	r.cancel()
+	return nil
+}
+
+func (r *minioObjectReader) openObject() error {
+	opts := minio.GetObjectOptions{}
+	if r.etag != "" {
+		// Make sure a resumed download continues reading the same object
+		if err := opts.SetMatchETag(r.etag); err != nil {
+			return err
+		}
+	}
+	if r.offset > 0 {
+		if err := opts.SetRange(r.offset, 0); err != nil {
+			return err
+		}
+	}
+
+	r.objectCtx, r.objectCancel = context.WithCancel(r.ctx)
+	object, err := r.cache.client.GetObject(r.objectCtx, r.cache.bucket, r.key, opts)
+	if err != nil {
+		r.objectCancel()
+		return err
+	}
+	r.object = object
+	if r.cache.retry.OperationTimeout > 0 {
+		r.watchdog = time.AfterFunc(r.cache.retry.OperationTimeout, r.objectCancel)
+	}
+	return nil
+}
+
+func (r *minioObjectReader) resetWatchdog() {
+	if r.watchdog != nil {
+		r.watchdog.Reset(r.cache.retry.OperationTimeout)
+	}
+}
+
+func (r *minioObjectReader) closeObject() {
+	if r.watchdog != nil {
+		r.watchdog.Stop()
+		r.watchdog = nil
+	}
+	if r.object != nil {
+		r.object.Close()
+		r.object = nil
+	}
+	if r.objectCancel != nil {
+		r.objectCancel()
+		r.objectCancel = nil
+	}
+}
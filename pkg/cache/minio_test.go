@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestObjectTags(t *testing.T) {
+	metadata := Metadata{
+		"ci-job-url":    "https://ci.example.com/job?id=1&attempt=2",
+		"host":          "büild-01",
+		"lockfile-hash": strings.Repeat("a", 300),
+	}
+	tags := objectTags(metadata)
+	if got, want := tags["ci-job-url"], "https://ci.example.com/job_id=1_attempt=2"; got != want {
+		t.Errorf("ci-job-url = %q, want %q", got, want)
+	}
+	if got, want := tags["host"], "b_ild-01"; got != want {
+		t.Errorf("host = %q, want %q", got, want)
+	}
+	if got := len(tags["lockfile-hash"]); got != maxTagValueLength {
+		t.Errorf("len(lockfile-hash) = %d, want %d", got, maxTagValueLength)
+	}
+
+	for i := 0; i < 15; i++ {
+		metadata[fmt.Sprintf("extra-%02d", i)] = "x"
+	}
+	if got := len(objectTags(metadata)); got != maxObjectTags {
+		t.Errorf("Got %d tags, want %d", got, maxObjectTags)
+	}
+}
+
+func TestUserMetadata(t *testing.T) {
+	headers := userMetadata(Metadata{"host": "büild\n01"})
+	if got, want := headers["host"], "b?ild?01"; got != want {
+		t.Errorf("host = %q, want %q", got, want)
+	}
+	if userMetadata(nil) != nil {
+		t.Errorf("Was expecting no user metadata")
+	}
+}
+
+func TestObjectMetadata(t *testing.T) {
+	metadata := objectMetadata(map[string]string{"Npmi-Version": "1.2.3", "Lockfile-Hash": "abc"})
+	if metadata["npmi-version"] != "1.2.3" || metadata["lockfile-hash"] != "abc" {
+		t.Errorf("objectMetadata() = %v", metadata)
+	}
+}
+
+func TestIsRetryableMinioError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"timeout", context.DeadlineExceeded, true},
+		{"canceled", context.Canceled, false},
+		{"connection refused", &url.Error{Op: "Put", URL: "http://minio", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, true},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"certificate", &url.Error{Op: "Put", URL: "https://minio", Err: x509.UnknownAuthorityError{}}, false},
+		{"invalid argument", minio.ErrorResponse{Code: "InvalidArgument", Message: "invalid bucket name"}, false},
+		{"server error", minio.ErrorResponse{StatusCode: http.StatusServiceUnavailable}, true},
+		{"not found", minio.ErrorResponse{StatusCode: http.StatusNotFound}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableMinioError(tt.err); got != tt.want {
+				t.Errorf("isRetryableMinioError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLockOwner(t *testing.T) {
+	first, err := newLockOwner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newLockOwner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Errorf("Lock owners are not unique: %s", first)
+	}
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// RetryPolicy describes how operations against a remote cache are retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts made before giving up
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. It doubles after every failed attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff limits the delay between two attempts
+	MaxBackoff time.Duration
+	// OperationTimeout limits the duration of a single attempt. For downloads it limits
+	// the time spent waiting for more data. Uploads are only limited by the deadline.
+	// Zero disables the timeout.
+	OperationTimeout time.Duration
+	// Deadline limits the total duration of an operation including all retries.
+	// Zero disables the deadline.
+	Deadline time.Duration
+}
+
+// DefaultRetryPolicy returns the retry policy used when nothing else is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:      3,
+		InitialBackoff:   500 * time.Millisecond,
+		MaxBackoff:       10 * time.Second,
+		OperationTimeout: 5 * time.Minute,
+		Deadline:         0,
+	}
+}
+
+// backoff returns the delay to wait before the given retry attempt (1-based)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// Add up to 20% of jitter to avoid clients retrying in lockstep
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// withDeadline returns a context limited by the overall deadline of the policy
+func (p RetryPolicy) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.Deadline <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, p.Deadline)
+}
+
+// withOperationTimeout returns a context limited by the timeout of a single attempt
+func (p RetryPolicy) withOperationTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.OperationTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, p.OperationTimeout)
+}
+
+// retry runs op until it succeeds, returns an error deemed permanent by isRetryable,
+// the attempts are exhausted or ctx is done.
+func retry(ctx context.Context, policy RetryPolicy, log hclog.Logger, isRetryable func(error) bool, op func(ctx context.Context) error) error {
+	attempts := policy.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		opCtx, cancel := policy.withOperationTimeout(ctx)
+		err = op(opCtx)
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if attempt >= attempts || !isRetryable(err) {
+			return err
+		}
+
+		delay := policy.backoff(attempt)
+		log.Warn("attempt failed, retrying", "attempt", attempt, "maxAttempts", attempts, "delay", delay, "error", err)
+		if !sleep(ctx, delay) {
+			return err
+		}
+	}
+}
+
+// sleep waits for the given duration and returns false if ctx was done before that
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestRetry(t *testing.T) {
+	errTransient := errors.New("transient")
+	errPermanent := errors.New("permanent")
+	isRetryable := func(err error) bool { return err == errTransient }
+
+	tests := []struct {
+		name         string
+		maxAttempts  int
+		errors       []error
+		wantErr      error
+		wantAttempts int
+	}{
+		{"success", 3, []error{nil}, nil, 1},
+		{"success after retries", 3, []error{errTransient, errTransient, nil}, nil, 3},
+		{"attempts exhausted", 2, []error{errTransient, errTransient, nil}, errTransient, 2},
+		{"permanent error", 3, []error{errPermanent, nil}, errPermanent, 1},
+		{"zero attempts runs once", 0, []error{errTransient, nil}, errTransient, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := RetryPolicy{MaxAttempts: tt.maxAttempts, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+			attempts := 0
+			err := retry(context.Background(), policy, hclog.NewNullLogger(), isRetryable, func(ctx context.Context) error {
+				err := tt.errors[attempts]
+				attempts++
+				return err
+			})
+			if err != tt.wantErr {
+				t.Errorf("retry() error = %v, want %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("retry() attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryOperationTimeout(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 2, OperationTimeout: 10 * time.Millisecond}
+	attempts := 0
+	err := retry(context.Background(), policy, hclog.NewNullLogger(), isRetryableMinioError, func(ctx context.Context) error {
+		attempts++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("retry() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if attempts != 2 {
+		t.Errorf("retry() attempts = %d, want 2", attempts)
+	}
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	tests := []struct {
+		attempt int
+		min     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{5, time.Second},
+		{10, time.Second},
+	}
+	for _, tt := range tests {
+		got := policy.backoff(tt.attempt)
+		if got < tt.min || got > tt.min+tt.min/5 {
+			t.Errorf("backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.min, tt.min+tt.min/5)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// ServerOptions describes the limits and access control of a cache server
+type ServerOptions struct {
+	// Token is required from clients as a bearer token. It may only be empty if AllowAnonymous is set.
+	Token string
+	// AllowAnonymous allows reading and storing entries without a token
+	AllowAnonymous bool
+	// MaxEntrySize limits the size of a single entry in bytes. Zero means no limit.
+	MaxEntrySize int64
+	// MaxSize limits the total size of entries in bytes. The least recently used entries
+	// are evicted to stay within the limit. Zero means no limit.
+	MaxSize int64
+}
+
+// ServerStats describes the contents and usage of a cache server
+type ServerStats struct {
+	Entries   int   `json:"entries"`
+	Size      int64 `json:"size"`
+	MaxSize   int64 `json:"maxSize,omitempty"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Puts      int64 `json:"puts"`
+	Evictions int64 `json:"evictions"`
+}
+
+// Server serves a directory-backed cache over HTTP for clients created using NewHTTPCache
+type Server struct {
+	cache   *localCache
+	options ServerOptions
+	log     hclog.Logger
+	handler http.Handler
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds the entries ordered from the most to the least recently used
+	lru   *list.List
+	stats ServerStats
+}
+
+type serverEntry struct {
+	key  string
+	size int64
+}
+
+// NewServer creates a cache server storing entries in dir
+func NewServer(dir string, options ServerOptions, log hclog.Logger) (*Server, error) {
+	if options.Token == "" && !options.AllowAnonymous {
+		return nil, errors.New("no token given and anonymous access not allowed")
+	}
+	cache, err := NewLocalCache(dir, log.Named("local"))
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		cache:   cache.(*localCache),
+		options: options,
+		log:     log,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	if err := s.loadEntries(); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /cache/{key...}", s.handleGet)
+	mux.HandleFunc("PUT /cache/{key...}", s.handlePut)
+	mux.HandleFunc("GET /stats", s.handleStats)
+	s.handler = mux
+	return s, nil
+}
+
+// loadEntries adds the entries already present in the directory. Their modification
+// time is the time they were last used. Files not named like keys aren't listed, so
+// that they are neither counted nor evicted.
+func (s *Server) loadEntries() error {
+	entries, err := s.cache.List(context.Background(), "")
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return a.ModTime.Compare(b.ModTime)
+	})
+	for _, entry := range entries {
+		s.add(entry.Key, entry.Size)
+	}
+	s.evict("")
+	s.log.Debug("Entries loaded", "entries", s.stats.Entries, "size", s.stats.Size)
+	return nil
+}
+
+// add records an entry as the most recently used one. The caller must hold s.mu
+// unless the server is not serving yet.
+func (s *Server) add(key string, size int64) {
+	if element, ok := s.entries[key]; ok {
+		entry := element.Value.(*serverEntry)
+		s.stats.Size += size - entry.size
+		entry.size = size
+		s.lru.MoveToFront(element)
+		return
+	}
+	s.entries[key] = s.lru.PushFront(&serverEntry{key, size})
+	s.stats.Entries++
+	s.stats.Size += size
+}
+
+// touch marks an entry as used. The caller must hold s.mu.
+func (s *Server) touch(key string) {
+	if element, ok := s.entries[key]; ok {
+		s.lru.MoveToFront(element)
+	}
+	// Persist the time of use so that the order survives restarts
+	now := time.Now()
+	if err := os.Chtimes(s.entryPath(key), now, now); err != nil && !os.IsNotExist(err) {
+		s.log.Warn("Could not update time of use", "key", key, "error", err)
+	}
+}
+
+// evict removes the least recently used entries other than keep until the total
+// size is within the limit. The caller must hold s.mu.
+func (s *Server) evict(keep string) {
+	if s.options.MaxSize <= 0 {
+		return
+	}
+	for element := s.lru.Back(); element != nil && s.stats.Size > s.options.MaxSize; {
+		entry := element.Value.(*serverEntry)
+		previous := element.Prev()
+		if entry.key != keep {
+			path := s.entryPath(entry.key)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				s.log.Error("Eviction failed", "key", entry.key, "error", err)
+				return
+			}
+			os.Remove(path + lockSuffix)
+			s.lru.Remove(element)
+			delete(s.entries, entry.key)
+			s.stats.Entries--
+			s.stats.Size -= entry.size
+			s.stats.Evictions++
+			s.log.Info("Evicted", "key", entry.key, "size", entry.size)
+		}
+		element = previous
+	}
+}
+
+// Stats returns the current statistics of the server
+func (s *Server) Stats() ServerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.MaxSize = s.options.MaxSize
+	return stats
+}
+
+// ServeHTTP serves HEAD, GET and PUT requests of entries at /cache/{key}, where the key may be
+// prefixed with a namespace, and statistics at /stats
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s.handler.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.options.Token == "" {
+		return s.options.AllowAnonymous
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.options.Token)) == 1
+}
+
+// entryPath returns the path of an entry known to have a valid key
+func (s *Server) entryPath(key string) string {
+	return filepath.Join(s.cache.dir, filepath.FromSlash(key))
+}
+
+// handleGet serves an entry. Also handles HEAD requests.
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	path, err := s.cache.joinPath(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		s.mu.Lock()
+		s.stats.Misses++
+		s.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		s.log.Error("Open failed", "key", key, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		s.log.Error("Stat failed", "key", key, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		s.mu.Lock()
+		s.stats.Hits++
+		s.touch(key)
+		s.mu.Unlock()
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+// handlePut stores an entry
+func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	path, err := s.cache.joinPath(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	maxSize := s.options.MaxEntrySize
+	if s.options.MaxSize > 0 && (maxSize <= 0 || s.options.MaxSize < maxSize) {
+		maxSize = s.options.MaxSize
+	}
+	if maxSize > 0 {
+		if r.ContentLength > maxSize {
+			http.Error(w, fmt.Sprintf("entry larger than %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	}
+
+	if err := s.cache.Put(r.Context(), key, r.Body); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("entry larger than %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		s.log.Error("Put failed", "key", key, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		s.log.Error("Stat failed", "key", key, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.Lock()
+	s.stats.Puts++
+	s.add(key, fi.Size())
+	s.evict(key)
+	s.mu.Unlock()
+
+	s.log.Debug("Stored", "key", key, "size", fi.Size())
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Stats())
+}
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func newTestServer(t *testing.T, dir string, options ServerOptions) (*Server, *httptest.Server) {
+	server, err := NewServer(dir, options, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	return server, httpServer
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	server, httpServer := newTestServer(t, dir, ServerOptions{Token: "secret", MaxEntrySize: 10, MaxSize: 20})
+	client, err := NewHTTPCache(httpServer.URL, "secret", hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found, err := client.Has(ctx, "a"); err != nil || found {
+		t.Errorf("Has() = %v, %v, want not found", found, err)
+	}
+	if err := client.Put(ctx, "a", strings.NewReader("aaaaaaaa")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if found, err := client.Has(ctx, "a"); err != nil || !found {
+		t.Errorf("Has() = %v, %v, want found", found, err)
+	}
+	reader, err := client.Get(ctx, "a")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.(io.Closer).Close()
+	if err != nil || string(data) != "aaaaaaaa" {
+		t.Errorf("Get() = %q, %v", data, err)
+	}
+
+	if err := client.Put(ctx, "big", strings.NewReader("01234567890")); err == nil {
+		t.Errorf("Was expecting an entry larger than the limit to be rejected")
+	}
+	if err := client.Put(ctx, "../escape", strings.NewReader("x")); err == nil {
+		t.Errorf("Was expecting an invalid key to be rejected")
+	}
+
+	// Using a makes b the least recently used entry, which is evicted when c is stored
+	if err := client.Put(ctx, "b", strings.NewReader("bbbbbbbb")); err != nil {
+		t.Fatal(err)
+	}
+	if reader, err := client.Get(ctx, "a"); err == nil {
+		reader.(io.Closer).Close()
+	}
+	if err := client.Put(ctx, "c", strings.NewReader("cccccccc")); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if found, _ := client.Has(ctx, key); found != want {
+			t.Errorf("Has(%s) = %v, want %v", key, found, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b")); !os.IsNotExist(err) {
+		t.Errorf("Evicted entry was not removed")
+	}
+
+	stats := server.Stats()
+	if stats.Entries != 2 || stats.Size != 16 || stats.Puts != 3 || stats.Evictions != 1 || stats.Hits != 2 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	namespaced, err := NewNamespacedCache(client, "team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := namespaced.Put(ctx, "a", strings.NewReader("n")); err != nil {
+		t.Fatalf("Put() in namespace error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "team-a", "a")); err != nil {
+		t.Errorf("Entry not stored in the namespace: %v", err)
+	}
+	if found, _ := namespaced.Has(ctx, "c"); found {
+		t.Errorf("Has() found an entry outside of the namespace")
+	}
+
+	// A restarted server knows the entries, ignoring unrelated files
+	unrelated := filepath.Join(dir, "unrelated file.txt")
+	if err := os.WriteFile(unrelated, []byte(strings.Repeat("x", 100)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	restarted, _ := newTestServer(t, dir, ServerOptions{AllowAnonymous: true, MaxSize: 20})
+	if stats := restarted.Stats(); stats.Entries != 3 || stats.Size != 17 || stats.Evictions != 0 {
+		t.Errorf("Stats() after restart = %+v", stats)
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Errorf("Unrelated file was removed: %v", err)
+	}
+}
+
+func TestNewServerRequiresToken(t *testing.T) {
+	if _, err := NewServer(t.TempDir(), ServerOptions{}, hclog.NewNullLogger()); err == nil {
+		t.Errorf("Was expecting a server without a token to be rejected unless anonymous access is allowed")
+	}
+}
+
+func TestServerUnauthorized(t *testing.T) {
+	_, httpServer := newTestServer(t, t.TempDir(), ServerOptions{Token: "secret"})
+	for _, token := range []string{"", "wrong"} {
+		client, err := NewHTTPCache(httpServer.URL, token, hclog.NewNullLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Has(context.Background(), "a"); err == nil {
+			t.Errorf("Was expecting token %q to be rejected", token)
+		}
+	}
+
+	resp, err := http.Get(httpServer.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET /stats = %s, want 401", resp.Status)
+	}
+}
+
+func TestRemainingSize(t *testing.T) {
+	reader := strings.NewReader("0123456789")
+	reader.Seek(4, io.SeekStart)
+	if size, ok := remainingSize(reader); !ok || size != 6 {
+		t.Errorf("remainingSize() = %d, %v, want 6", size, ok)
+	}
+	if position, _ := reader.Seek(0, io.SeekCurrent); position != 4 {
+		t.Errorf("Position after remainingSize() = %d, want 4", position)
+	}
+	if _, ok := remainingSize(io.MultiReader()); ok {
+		t.Errorf("Was expecting no size for a reader which is not seekable")
+	}
+}
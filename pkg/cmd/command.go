@@ -2,24 +2,98 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
+// killDelay is the time given to a cancelled command to exit before it is killed
+const killDelay = 10 * time.Second
+
 // Runner can run external commands and shell commands
 type Runner interface {
-	RunCommand(name string, args ...string) (stdout string, stderr string, err error)
-	RunShellCommand(commandLine string) (stdout string, stderr string, err error)
+	RunCommand(ctx context.Context, name string, args ...string) (stdout string, stderr string, err error)
+	RunShellCommand(ctx context.Context, commandLine string, opts ...RunOption) (stdout string, stderr string, err error)
+}
+
+// RunOptions describes how a shell command is run
+type RunOptions struct {
+	// Dir is the working directory of the command. Empty means the current directory.
+	Dir string
+	// Env lists extra env variables in the form KEY=value, added to the environment of the current process
+	Env []string
 }
 
-type defaultRunner struct{}
+// RunOption configures how a shell command is run
+type RunOption func(*RunOptions)
+
+// WithDir runs a command in the given working directory. A relative dir is relative
+// to the directory given by an earlier option, if any.
+func WithDir(dir string) RunOption {
+	return func(o *RunOptions) {
+		if o.Dir != "" && !filepath.IsAbs(dir) {
+			dir = filepath.Join(o.Dir, dir)
+		}
+		o.Dir = dir
+	}
+}
+
+// WithEnv adds env variables in the form KEY=value to the environment of a command
+func WithEnv(env ...string) RunOption {
+	return func(o *RunOptions) {
+		o.Env = append(o.Env, env...)
+	}
+}
+
+// NewRunOptions applies opts to empty RunOptions
+func NewRunOptions(opts ...RunOption) *RunOptions {
+	o := &RunOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// newRunOptions applies the options of a runner followed by the options of a single command
+func newRunOptions(runnerOpts []RunOption, opts []RunOption) *RunOptions {
+	o := NewRunOptions(runnerOpts...)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// apply configures cmd according to the options
+func (o *RunOptions) apply(cmd *exec.Cmd) {
+	cmd.Dir = o.Dir
+	if len(o.Env) > 0 {
+		cmd.Env = append(os.Environ(), o.Env...)
+	}
+}
+
+type defaultRunner struct {
+	options []RunOption
+}
+
+// NewRunner returns a Runner running every command using opts, e.g. in a project directory.
+// The options given to RunShellCommand are applied after them.
+func NewRunner(opts ...RunOption) Runner {
+	return &defaultRunner{options: opts}
+}
 
-func NewRunner() Runner {
-	return &defaultRunner{}
+// RunCommand executes a command. When ctx is cancelled, the command and its
+// children are terminated.
+func (r *defaultRunner) RunCommand(ctx context.Context, name string, args ...string) (stdout string, stderr string, err error) {
+	return r.run(ctx, NewRunOptions(r.options...), name, args...)
 }
 
-func (r *defaultRunner) RunCommand(name string, args ...string) (stdout string, stderr string, err error) {
-	cmd := exec.Command(name, args...)
+func (r *defaultRunner) run(ctx context.Context, options *RunOptions, name string, args ...string) (stdout string, stderr string, err error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	options.apply(cmd)
+	SetCancelBehaviour(cmd)
 	var stdoutBuf bytes.Buffer
 	var stderrBuf bytes.Buffer
 	cmd.Stdout = &stdoutBuf
@@ -1,6 +1,23 @@
 package cmd
 
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
 // RunShellCommand executes a shell command
-func (r *defaultRunner) RunShellCommand(commandLine string) (stdout string, stderr string, err error) {
-	return r.RunCommand("sh", "-c", commandLine)
+func (r *defaultRunner) RunShellCommand(ctx context.Context, commandLine string, opts ...RunOption) (stdout string, stderr string, err error) {
+	return r.run(ctx, newRunOptions(r.options, opts), "sh", "-c", commandLine)
+}
+
+// SetCancelBehaviour runs cmd in its own process group and makes cancellation
+// send SIGTERM to the whole group, so that grandchildren spawned by e.g. npm
+// are terminated as well. The group is killed if it has not exited after killDelay.
+func SetCancelBehaviour(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+	cmd.WaitDelay = killDelay
 }
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestRunShellCommandWithOptions(t *testing.T) {
+	dir := t.TempDir()
+	for name, runner := range map[string]Runner{"default": NewRunner(), "streaming": NewStreamingRunner(hclog.NewNullLogger(), 10)} {
+		t.Run(name, func(t *testing.T) {
+			stdout, _, err := runner.RunShellCommand(context.Background(), `echo "$(pwd) $NPMI_TEST_VALUE"`, WithDir(dir), WithEnv("NPMI_TEST_VALUE=hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := dir + " hello"; stdout != want {
+				t.Errorf("stdout = %q, want %q", stdout, want)
+			}
+		})
+	}
+}
+
+func TestRunnerOptions(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := []RunOption{WithDir(dir), WithEnv("NPMI_TEST_VALUE=hello")}
+	for name, runner := range map[string]Runner{"default": NewRunner(opts...), "streaming": NewStreamingRunner(hclog.NewNullLogger(), 10, opts...)} {
+		t.Run(name, func(t *testing.T) {
+			stdout, _, err := runner.RunCommand(context.Background(), "pwd")
+			if err != nil || stdout != dir {
+				t.Errorf("RunCommand() = %q, %v, want %q", stdout, err, dir)
+			}
+
+			// A relative dir is relative to the directory of the runner
+			stdout, _, err = runner.RunShellCommand(context.Background(), `echo "$(pwd) $NPMI_TEST_VALUE"`, WithDir("sub"))
+			if want := filepath.Join(dir, "sub") + " hello"; err != nil || stdout != want {
+				t.Errorf("RunShellCommand() = %q, %v, want %q", stdout, err, want)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package cmd
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 type RunCommandCall struct {
 	Name string
@@ -13,10 +16,11 @@ func (c *RunCommandCall) String() string {
 
 type RunShellCommandCall struct {
 	CommandLine string
+	Options     *RunOptions
 }
 
 func (c *RunShellCommandCall) String() string {
-	return fmt.Sprintf("CommandLine: %+v", c.CommandLine)
+	return fmt.Sprintf("CommandLine: %+v, Options: %+v", c.CommandLine, *c.Options)
 }
 
 // SpyRunner is a cmd.Runner for testing purposes
@@ -28,7 +32,7 @@ type SpyRunner struct {
 	RunShellCommandCalls []*RunShellCommandCall
 }
 
-func (r *SpyRunner) RunCommand(name string, args ...string) (stdout string, stderr string, err error) {
+func (r *SpyRunner) RunCommand(ctx context.Context, name string, args ...string) (stdout string, stderr string, err error) {
 	r.RunCommandCalls = append(r.RunCommandCalls, &RunCommandCall{
 		Name: name,
 		Args: args,
@@ -36,9 +40,10 @@ func (r *SpyRunner) RunCommand(name string, args ...string) (stdout string, stde
 	return r.Stdout, r.Stderr, r.Error
 }
 
-func (r *SpyRunner) RunShellCommand(commandLine string) (stdout string, stderr string, err error) {
+func (r *SpyRunner) RunShellCommand(ctx context.Context, commandLine string, opts ...RunOption) (stdout string, stderr string, err error) {
 	r.RunShellCommandCalls = append(r.RunShellCommandCalls, &RunShellCommandCall{
 		CommandLine: commandLine,
+		Options:     NewRunOptions(opts...),
 	})
 	return r.Stdout, r.Stderr, r.Error
 }
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// maxLineLength limits the length of a line logged by the streaming runner. Longer lines,
+// e.g. progress output without newlines, are logged in pieces.
+const maxLineLength = 64 * 1024
+
+type streamingRunner struct {
+	log       hclog.Logger
+	tailLines int
+	options   []RunOption
+}
+
+// NewStreamingRunner returns a Runner which forwards the output of commands line by line
+// to log as it is produced, with a stream field telling stdout and stderr apart.
+// The stdout and stderr returned contain the last tailLines lines of output.
+// Every command is run using opts, like with NewRunner.
+func NewStreamingRunner(log hclog.Logger, tailLines int, opts ...RunOption) Runner {
+	return &streamingRunner{
+		log:       log,
+		tailLines: tailLines,
+		options:   opts,
+	}
+}
+
+// RunCommand executes a command, streaming its output. When ctx is cancelled,
+// the command and its children are terminated.
+func (r *streamingRunner) RunCommand(ctx context.Context, name string, args ...string) (stdout string, stderr string, err error) {
+	return r.run(ctx, NewRunOptions(r.options...), name, args...)
+}
+
+// RunShellCommand executes a shell command, streaming its output
+func (r *streamingRunner) RunShellCommand(ctx context.Context, commandLine string, opts ...RunOption) (stdout string, stderr string, err error) {
+	return r.run(ctx, newRunOptions(r.options, opts), "sh", "-c", commandLine)
+}
+
+func (r *streamingRunner) run(ctx context.Context, options *RunOptions, name string, args ...string) (stdout string, stderr string, err error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	options.apply(cmd)
+	SetCancelBehaviour(cmd)
+	stdoutWriter := newLineWriter(r.log, "stdout", r.tailLines)
+	stderrWriter := newLineWriter(r.log, "stderr", r.tailLines)
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+	err = cmd.Run()
+	stdout = strings.TrimSpace(stdoutWriter.Tail())
+	stderr = strings.TrimSpace(stderrWriter.Tail())
+	return
+}
+
+// lineWriter logs every line written to it and keeps a bounded tail of the lines
+type lineWriter struct {
+	mu        sync.Mutex
+	log       hclog.Logger
+	stream    string
+	tailLines int
+	partial   []byte
+	tail      []string
+}
+
+func newLineWriter(log hclog.Logger, stream string, tailLines int) *lineWriter {
+	return &lineWriter{
+		log:       log,
+		stream:    stream,
+		tailLines: tailLines,
+	}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(string(bytes.TrimRight(w.partial[:i], "\r")))
+		w.partial = w.partial[i+1:]
+	}
+	for len(w.partial) >= maxLineLength {
+		w.emit(string(w.partial[:maxLineLength]))
+		w.partial = w.partial[maxLineLength:]
+	}
+	return len(p), nil
+}
+
+// emit logs a line and adds it to the tail
+func (w *lineWriter) emit(line string) {
+	w.log.Info(line, "stream", w.stream)
+	if w.tailLines <= 0 {
+		return
+	}
+	w.tail = append(w.tail, line)
+	if len(w.tail) > w.tailLines {
+		w.tail = w.tail[len(w.tail)-w.tailLines:]
+	}
+}
+
+// Tail flushes a pending partial line and returns the last lines written
+func (w *lineWriter) Tail() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.partial) > 0 {
+		w.emit(string(w.partial))
+		w.partial = nil
+	}
+	return strings.Join(w.tail, "\n")
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestStreamingRunner(t *testing.T) {
+	var logOutput bytes.Buffer
+	log := hclog.New(&hclog.LoggerOptions{
+		Output: &logOutput,
+		Level:  hclog.Info,
+	})
+	runner := NewStreamingRunner(log, 2)
+
+	stdout, stderr, err := runner.RunShellCommand(context.Background(), "printf 'one\\ntwo\\nthree'; echo oops >&2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout != "two\nthree" {
+		t.Errorf("Was expecting stdout to contain the last two lines, got %q", stdout)
+	}
+	if stderr != "oops" {
+		t.Errorf("Was expecting stderr to be oops, got %q", stderr)
+	}
+	for _, want := range []string{"one: stream=stdout", "three: stream=stdout", "oops: stream=stderr"} {
+		if !strings.Contains(logOutput.String(), want) {
+			t.Errorf("Was expecting %q to be logged, got %q", want, logOutput.String())
+		}
+	}
+}
+
+func TestLineWriterWithoutTail(t *testing.T) {
+	w := newLineWriter(hclog.NewNullLogger(), "stdout", 0)
+	if _, err := w.Write([]byte("a\nb\n")); err != nil {
+		t.Fatal(err)
+	}
+	if tail := w.Tail(); tail != "" {
+		t.Errorf("Was expecting no tail, got %q", tail)
+	}
+}
+
+func TestLineWriterSplitsLongLines(t *testing.T) {
+	w := newLineWriter(hclog.NewNullLogger(), "stdout", 10)
+	chunk := bytes.Repeat([]byte("x"), 1000)
+	for i := 0; i < 100; i++ {
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(w.partial) >= maxLineLength {
+		t.Errorf("Partial line of %d bytes exceeds the maximum of %d", len(w.partial), maxLineLength)
+	}
+	lines := strings.Split(w.Tail(), "\n")
+	if len(lines) != 2 || len(lines[0]) != maxLineLength || len(lines[1]) != 100*1000-maxLineLength {
+		t.Errorf("Was expecting the output to be split at %d bytes, got lines of %d bytes", maxLineLength, len(lines[0]))
+	}
+}
@@ -1,8 +1,10 @@
 package files
 
 import (
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 )
 
 // DirectoryExists determines if a given path exists and is a directory or not
@@ -29,6 +31,21 @@ func IsExistingFile(path string) (bool, error) {
 	return !info.IsDir(), nil
 }
 
+// WalkFilesAndSymlinks walks a directory tree calling fn for each regular file and symlink
+func WalkFilesAndSymlinks(directory string, fn func(file string, fi os.FileInfo) error) error {
+	return filepath.Walk(directory, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip if not a file or symlink
+		if !(fi.Mode().IsRegular() || fi.Mode()&os.ModeSymlink != 0) {
+			return nil
+		}
+		return fn(file, fi)
+	})
+}
+
 // RemoveFilesNotPresentInManifest compares a real directory tree with a list of
 // files to keep and removes extra files
 func RemoveFilesNotPresentInManifest(directory string, filesTokeep []string) ([]string, error) {
@@ -40,23 +57,66 @@ func RemoveFilesNotPresentInManifest(directory string, filesTokeep []string) ([]
 		m[f] = struct{}{}
 	}
 
-	return filesRemoved, filepath.Walk(directory, func(file string, fi os.FileInfo, err error) error {
+	return filesRemoved, WalkFilesAndSymlinks(directory, func(file string, fi os.FileInfo) error {
+		// Delete files not present in manifest
+		if _, ok := m[file]; !ok {
+			filesRemoved = append(filesRemoved, file)
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CopyDirectory copies the regular files, symlinks and directories of a directory tree
+// into dst, skipping directories with the given names
+func CopyDirectory(src string, dst string, skipDirs ...string) error {
+	return filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-
-		// Skip if not a file or symlink
-		if !(fi.Mode().IsRegular() || fi.Mode()&os.ModeSymlink != 0) {
-			return nil
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
 		}
+		target := filepath.Join(dst, rel)
 
-		// Delete files not present in manifest
-		if _, ok := m[file]; !ok {
-			filesRemoved = append(filesRemoved, file)
-			if err = os.Remove(file); err != nil {
+		switch {
+		case fi.IsDir():
+			if rel != "." && slices.Contains(skipDirs, fi.Name()) {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, fi.Mode().Perm()|0700)
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(file)
+			if err != nil {
 				return err
 			}
+			return os.Symlink(link, target)
+		case fi.Mode().IsRegular():
+			return copyFile(file, target, fi.Mode().Perm())
+		default:
+			// Skip sockets, devices and the like
+			return nil
 		}
-		return nil
 	})
 }
+
+func copyFile(src string, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
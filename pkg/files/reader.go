@@ -0,0 +1,25 @@
+package files
+
+import (
+	"context"
+	"io"
+)
+
+// contextReader is a reader which stops reading once its context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// NewContextReader returns a reader which reads from r until ctx is done, making long
+// copies such as archive creation and extraction cancellable
+func NewContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx, r}
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,59 @@
+package lock
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// pollInterval is the delay between attempts to take a lock held by someone else
+const pollInterval = 250 * time.Millisecond
+
+// FileLock is an exclusive advisory lock on a file, shared between processes
+type FileLock struct {
+	file *os.File
+}
+
+// TryAcquire tries to take the lock at path without waiting.
+// It returns nil if the lock is held by someone else.
+func TryAcquire(path string) (*FileLock, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := tryLock(f)
+	if err != nil || !locked {
+		f.Close()
+		return nil, err
+	}
+	return &FileLock{f}, nil
+}
+
+// Acquire takes the lock at path, waiting until it is available or ctx is done
+func Acquire(ctx context.Context, path string) (*FileLock, error) {
+	for {
+		l, err := TryAcquire(path)
+		if err != nil || l != nil {
+			return l, err
+		}
+
+		t := time.NewTimer(pollInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Release releases the lock. The lock file is left in place as removing it
+// would race with other processes waiting for it.
+func (l *FileLock) Release() error {
+	if err := unlock(l.file); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,58 @@
+package lock
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTryAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+
+	first, err := TryAcquire(path)
+	if err != nil || first == nil {
+		t.Fatalf("TryAcquire() = %v, %v, want a lock", first, err)
+	}
+
+	second, err := TryAcquire(path)
+	if err != nil || second != nil {
+		t.Fatalf("TryAcquire() of a held lock = %v, %v, want nil, nil", second, err)
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+
+	third, err := TryAcquire(path)
+	if err != nil || third == nil {
+		t.Fatalf("TryAcquire() after release = %v, %v, want a lock", third, err)
+	}
+	third.Release()
+}
+
+func TestAcquireWaits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+
+	held, err := TryAcquire(path)
+	if err != nil || held == nil {
+		t.Fatalf("TryAcquire() = %v, %v, want a lock", held, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := Acquire(ctx, path); err != context.DeadlineExceeded {
+		t.Fatalf("Acquire() of a held lock error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		held.Release()
+	}()
+
+	l, err := Acquire(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	l.Release()
+}
@@ -1,9 +1,11 @@
 package npmi
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 
 	"github.com/hermo/npmi-go/pkg/cmd"
 )
@@ -11,9 +13,16 @@ import (
 type Config struct {
 	nodeBinary     string
 	npmBinary      string
+	nodeVersion    string
 	Platform       string
 	productionMode bool
-	runner         cmd.Runner
+	// nodePlatform and distribution are the parts of Platform not depending on the mode
+	nodePlatform string
+	distribution string
+	// runner is nil unless a custom runner was given
+	runner     cmd.Runner
+	projectDir string
+	env        []string
 }
 
 type configBuilder struct {
@@ -22,12 +31,14 @@ type configBuilder struct {
 	shouldFindBinariesInPath   bool
 	productionModeDeterminator func() bool
 	runner                     cmd.Runner
+	osReleaseFile              string
+	projectDir                 string
+	env                        []string
 }
 
 func NewConfigBuilder() *configBuilder {
 	return &configBuilder{
 		productionModeDeterminator: defaultProductionModeDeterminator,
-		runner:                     cmd.NewRunner(),
 	}
 }
 
@@ -43,15 +54,32 @@ func (b *configBuilder) WithNpmBinary(npmBinary string) {
 	b.npmBinary = npmBinary
 }
 
+// WithRunner runs node, npm and shell commands using runner, which is responsible for
+// running them in the project directory and with the env variables given to the builder
 func (b *configBuilder) WithRunner(runner cmd.Runner) {
 	b.runner = runner
 }
 
+// WithProjectDir uses the project in dir instead of the current directory
+func (b *configBuilder) WithProjectDir(dir string) {
+	b.projectDir = dir
+}
+
+// WithEnv adds env variables in the form KEY=value to the environment of node, npm and shell commands
+func (b *configBuilder) WithEnv(env ...string) {
+	b.env = append(b.env, env...)
+}
+
+// WithOsDistribution includes the OS distribution read from the given os-release file in the platform
+func (b *configBuilder) WithOsDistribution(osReleaseFile string) {
+	b.osReleaseFile = osReleaseFile
+}
+
 func (b *configBuilder) WithProductionModeDeterminatorFunc(determinator func() bool) {
 	b.productionModeDeterminator = determinator
 }
 
-func (b *configBuilder) Build() (*Config, error) {
+func (b *configBuilder) Build(ctx context.Context) (*Config, error) {
 	if b.shouldFindBinariesInPath {
 		var err error
 		b.nodeBinary, b.npmBinary, err = findNodeBinariesInPath()
@@ -59,18 +87,71 @@ func (b *configBuilder) Build() (*Config, error) {
 			return nil, err
 		}
 	}
-	productionMode := b.productionModeDeterminator()
-	platform, err := getPlatform(b.runner, b.nodeBinary, productionMode)
-	if err != nil {
-		return nil, err
-	}
-	return &Config{
+	config := &Config{
 		nodeBinary:     b.nodeBinary,
 		npmBinary:      b.npmBinary,
 		runner:         b.runner,
-		Platform:       platform,
-		productionMode: productionMode,
-	}, nil
+		projectDir:     b.projectDir,
+		env:            b.env,
+		productionMode: b.productionModeDeterminator(),
+	}
+	nodePlatform, err := determineNodeVersion(ctx, config.commandRunner(), b.nodeBinary)
+	if err != nil {
+		return nil, err
+	}
+	if b.osReleaseFile != "" {
+		if config.distribution, err = determineOsDistribution(b.osReleaseFile); err != nil {
+			return nil, err
+		}
+	}
+	config.nodePlatform = nodePlatform
+	config.nodeVersion, _, _ = strings.Cut(nodePlatform, "-")
+	config.Platform = formatPlatform(nodePlatform, config.distribution, config.productionMode)
+	return config, nil
+}
+
+// withOptions returns a copy of the config whose mode and OS distribution follow the
+// options, which take precedence over the ones the config was built with
+func (c *Config) withOptions(options *Options) (*Config, error) {
+	config := *c
+	switch options.Mode {
+	case ModeProduction:
+		config.productionMode = true
+	case ModeDevelopment:
+		config.productionMode = false
+	}
+	if options.PlatformDistro && config.distribution == "" {
+		distribution, err := determineOsDistribution(defaultOsReleaseFile)
+		if err != nil {
+			return nil, err
+		}
+		config.distribution = distribution
+	}
+	// Configs not built using a builder only have a Platform
+	if config.nodePlatform != "" {
+		config.Platform = formatPlatform(config.nodePlatform, config.distribution, config.productionMode)
+	}
+	return &config, nil
+}
+
+// runOptions returns the options commands are run with in the project
+func (c *Config) runOptions() []cmd.RunOption {
+	var opts []cmd.RunOption
+	if c.projectDir != "" {
+		opts = append(opts, cmd.WithDir(c.projectDir))
+	}
+	if len(c.env) > 0 {
+		opts = append(opts, cmd.WithEnv(c.env...))
+	}
+	return opts
+}
+
+// commandRunner returns the custom runner of the config or a runner running commands with runOptions
+func (c *Config) commandRunner() cmd.Runner {
+	if c.runner != nil {
+		return c.runner
+	}
+	return cmd.NewRunner(c.runOptions()...)
 }
 
 func findNodeBinariesInPath() (nodePath string, npmPath string, err error) {
@@ -86,10 +167,11 @@ func findNodeBinariesInPath() (nodePath string, npmPath string, err error) {
 	return
 }
 
-func getPlatform(runner cmd.Runner, nodeBinary string, productionMode bool) (string, error) {
-	platform, err := determineNodeVersion(runner, nodeBinary)
-	if err != nil {
-		return "", err
+// formatPlatform returns the platform of the cache key
+func formatPlatform(nodePlatform string, distribution string, productionMode bool) string {
+	platform := nodePlatform
+	if distribution != "" {
+		platform += "-" + distribution
 	}
 
 	if productionMode {
@@ -98,17 +180,70 @@ func getPlatform(runner cmd.Runner, nodeBinary string, productionMode bool) (str
 		platform += "-dev"
 	}
 
-	return platform, nil
+	return platform
 }
 
-func determineNodeVersion(runner cmd.Runner, nodeBinary string) (string, error) {
-	version, stdErr, err := runner.RunCommand(nodeBinary, "-p", `process.version + "-" + process.platform + "-" + process.arch`)
+// nodePlatformScript prints the Node.js version, OS and architecture followed by the libc
+// flavour and version on Linux and the ABI version of native modules, e.g.
+// v20.10.0-linux-x64-glibc2.36-abi115 or v20.10.0-linux-x64-musl-abi115
+const nodePlatformScript = `(() => {
+	const parts = [process.version, process.platform, process.arch];
+	if (process.platform === "linux") {
+		let report = null;
+		try {
+			if (process.report) {
+				process.report.excludeNetwork = true;
+				report = process.report.getReport();
+			}
+		} catch (e) {}
+		if (report && report.header && report.header.glibcVersionRuntime) {
+			parts.push("glibc" + report.header.glibcVersionRuntime);
+		} else if (report && (report.sharedObjects || []).some((f) => f.includes("musl"))) {
+			parts.push("musl");
+		}
+	}
+	if (process.versions.modules) {
+		parts.push("abi" + process.versions.modules);
+	}
+	return parts.join("-");
+})()`
+
+func determineNodeVersion(ctx context.Context, runner cmd.Runner, nodeBinary string) (string, error) {
+	version, stdErr, err := runner.RunCommand(ctx, nodeBinary, "-p", nodePlatformScript)
 	if err != nil {
 		return stdErr, fmt.Errorf("can't run node from \"%s\": %v", nodeBinary, err)
 	}
 	return version, nil
 }
 
+// determineOsDistribution returns the ID and VERSION_ID from an os-release file, e.g. debian12 or alpine3.19.1.
+// A missing file results in an empty distribution.
+func determineOsDistribution(osReleaseFile string) (string, error) {
+	data, err := os.ReadFile(osReleaseFile)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("can't read OS distribution: %v", err)
+	}
+
+	var id, versionID string
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			id = value
+		case "VERSION_ID":
+			versionID = value
+		}
+	}
+	return strings.ReplaceAll(id+versionID, "-", "_"), nil
+}
+
 // defaultProductionModeDeterminator determines whether or not Node is running in production mode
 func defaultProductionModeDeterminator() bool {
 	return os.Getenv("NODE_ENV") == "production"
@@ -0,0 +1,56 @@
+package npmi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileNames lists the names of configuration files looked up in a directory, in order of preference
+var configFileNames = []string{".npmirc.yaml", ".npmirc.yml", ".npmirc.json"}
+
+// FindConfigFile returns the path of the first configuration file found in dir
+// or an empty string if there is none
+func FindConfigFile(dir string) (string, error) {
+	for _, name := range configFileNames {
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", err
+		}
+		if !info.IsDir() {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+// LoadConfigFile reads a YAML or JSON configuration file into options.
+// Only the settings present in the file are changed, so several files may be
+// loaded on top of each other. Unknown settings are reported as errors.
+func LoadConfigFile(path string, options *Options) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// JSON is a subset of YAML, so a single decoder handles both formats
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(options); err != nil {
+		if errors.Is(err, io.EOF) {
+			// Empty file
+			return nil
+		}
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
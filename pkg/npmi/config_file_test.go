@@ -0,0 +1,101 @@
+package npmi
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+	}{
+		{"yaml", ".npmirc.yaml", `
+loglevel: debug
+precache: npm run build
+minio: true
+minioCache:
+  endpoint: minio.example.com:9000
+  timeout: 30s
+tarAbsolutePaths: false
+`},
+		{"json", ".npmirc.json", `{
+  "loglevel": "debug",
+  "precache": "npm run build",
+  "minio": true,
+  "minioCache": {"endpoint": "minio.example.com:9000", "timeout": "30s"},
+  "tarAbsolutePaths": false
+}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, tt.filename), []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			path, err := FindConfigFile(dir)
+			if err != nil || filepath.Base(path) != tt.filename {
+				t.Fatalf("FindConfigFile() = %q, %v, want %s", path, err, tt.filename)
+			}
+
+			options := &Options{
+				LogLevel:         Info,
+				UseLocalCache:    true,
+				TarAbsolutePaths: true,
+				LocalCache:       &LocalCacheOptions{Dir: "/tmp"},
+				MinioCache:       &MinioCacheOptions{Bucket: "npmi", UseTLS: true},
+			}
+			if err := LoadConfigFile(path, options); err != nil {
+				t.Fatalf("LoadConfigFile() error = %v", err)
+			}
+
+			// Values present in the file
+			if options.LogLevel != Debug || options.PrecacheCommand != "npm run build" || !options.UseMinioCache || options.TarAbsolutePaths {
+				t.Errorf("settings from file not applied: %+v", options)
+			}
+			if options.MinioCache.Endpoint != "minio.example.com:9000" || options.MinioCache.Timeout != 30*time.Second {
+				t.Errorf("minio settings from file not applied: %+v", options.MinioCache)
+			}
+			// Values not present in the file
+			if !options.UseLocalCache || options.LocalCache.Dir != "/tmp" || options.MinioCache.Bucket != "npmi" || !options.MinioCache.UseTLS {
+				t.Errorf("settings not in file were changed: %+v %+v %+v", options, options.LocalCache, options.MinioCache)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"unknown setting", "precahce: npm run build\n", "precahce"},
+		{"invalid loglevel", "loglevel: verbose\n", "invalid loglevel"},
+		{"invalid duration", "lockTimeout: soon\n", "soon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".npmirc.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := LoadConfigFile(path, &Options{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadConfigFile() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFindConfigFileNone(t *testing.T) {
+	path, err := FindConfigFile(t.TempDir())
+	if path != "" || err != nil {
+		t.Errorf("FindConfigFile() = %q, %v, want no file", path, err)
+	}
+}
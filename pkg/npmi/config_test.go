@@ -0,0 +1,69 @@
+package npmi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hermo/npmi-go/pkg/cmd"
+)
+
+func TestDetermineOsDistribution(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"Debian", "PRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\nNAME=\"Debian GNU/Linux\"\nVERSION_ID=\"12\"\nID=debian\n", "debian12"},
+		{"Alpine", "NAME=\"Alpine Linux\"\nID=alpine\nVERSION_ID=3.19.1\n", "alpine3.19.1"},
+		{"Rolling", "ID=arch\n", "arch"},
+		{"Dashes", "ID=opensuse-leap\nVERSION_ID='15.5'\n", "opensuse_leap15.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := determineOsDistribution(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("determineOsDistribution() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	got, err := determineOsDistribution(filepath.Join(dir, "missing"))
+	if err != nil || got != "" {
+		t.Errorf("Was expecting a missing file to be ignored, got %q, %v", got, err)
+	}
+}
+
+func TestBuildWithOsDistribution(t *testing.T) {
+	osRelease := filepath.Join(t.TempDir(), "os-release")
+	if err := os.WriteFile(osRelease, []byte("ID=alpine\nVERSION_ID=3.19.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := &cmd.SpyRunner{Stdout: "v20.10.0-linux-x64-musl-abi115"}
+	builder := NewConfigBuilder()
+	builder.WithRunner(runner)
+	builder.WithOsDistribution(osRelease)
+	builder.WithProductionModeDeterminatorFunc(func() bool { return true })
+	config, err := builder.Build(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "v20.10.0-linux-x64-musl-abi115-alpine3.19.1-prod"
+	if config.Platform != want {
+		t.Errorf("Platform = %s, want %s", config.Platform, want)
+	}
+	if len(runner.RunCommandCalls) != 1 || runner.RunCommandCalls[0].Args[1] != nodePlatformScript {
+		t.Errorf("Was expecting node to be run with the platform script, got %v", runner.RunCommandCalls)
+	}
+}
@@ -0,0 +1,98 @@
+package npmi
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hermo/npmi-go/pkg/cmd"
+	"github.com/hermo/npmi-go/pkg/hash"
+)
+
+// Hook is a shell command run at a given point of the installation
+type Hook struct {
+	// Name identifies the hook in the cache key inputs listed in logs and the run report.
+	// Defaults to a prefix of the hash of the hook, as the command may contain secrets.
+	Name string `yaml:"name"`
+	// Command is the shell command to run
+	Command string `yaml:"command"`
+	// Dir is the working directory of the command, relative to the project directory
+	Dir string `yaml:"dir"`
+	// Env lists extra env variables of the command
+	Env map[string]string `yaml:"env"`
+	// Timeout limits the duration of the command. Zero disables the timeout.
+	Timeout time.Duration `yaml:"timeout"`
+	// AffectsKey tells whether the hook is included in the cache key (Default: true).
+	// Hooks which don't change the installed packages, e.g. notifications, may be left out.
+	AffectsKey *bool `yaml:"affectsKey"`
+}
+
+// affectsKey tells whether the hook is included in the cache key
+func (h Hook) affectsKey() bool {
+	return h.AffectsKey == nil || *h.AffectsKey
+}
+
+// env returns the extra env variables of the hook in the form KEY=value, sorted by key
+func (h Hook) env() []string {
+	env := make([]string, 0, len(h.Env))
+	for key, value := range h.Env {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// runOptions returns the options the hook command is run with
+func (h Hook) runOptions() []cmd.RunOption {
+	var opts []cmd.RunOption
+	if h.Dir != "" {
+		opts = append(opts, cmd.WithDir(h.Dir))
+	}
+	if len(h.Env) > 0 {
+		opts = append(opts, cmd.WithEnv(h.env()...))
+	}
+	return opts
+}
+
+// withTimeout returns a context limited by the timeout of the hook
+func (h Hook) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if h.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, h.Timeout)
+}
+
+// hookNameLength is the length of the hash prefix naming a hook without a name
+const hookNameLength = 12
+
+// keyInput returns the hook as a cache key input. The timeout does not affect the key.
+func (h Hook) keyInput() (KeyInput, error) {
+	parts := append([]string{h.Command, h.Dir}, h.env()...)
+	hookHash, err := hash.String(strings.Join(parts, "\x00"))
+	if err != nil {
+		return KeyInput{}, fmt.Errorf("can't hash hook: %v", err)
+	}
+	name := h.Name
+	if name == "" {
+		name = hookHash[:hookNameLength]
+	}
+	return KeyInput{Kind: "hook", Name: name, Hash: hookHash}, nil
+}
+
+// hookKeyInputs returns the hooks affecting the cache key as key inputs
+func hookKeyInputs(hooks []Hook) ([]KeyInput, error) {
+	var inputs []KeyInput
+	for _, hook := range hooks {
+		if !hook.affectsKey() {
+			continue
+		}
+		input, err := hook.keyInput()
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, nil
+}
@@ -0,0 +1,109 @@
+package npmi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hermo/npmi-go/pkg/cmd"
+)
+
+func TestLoadConfigFileWithHooks(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), ".npmirc.yaml")
+	content := `
+precache: npm run build
+precacheHooks:
+  - command: npm run generate
+    dir: packages/app
+    env:
+      NODE_OPTIONS: --max-old-space-size=4096
+    timeout: 5m
+  - command: curl -X POST https://example.com/built
+    affectsKey: false
+`
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	options := &Options{}
+	if err := LoadConfigFile(configFile, options); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(options.PrecacheHooks) != 2 {
+		t.Fatalf("Was expecting 2 hooks, got %+v", options.PrecacheHooks)
+	}
+	hook := options.PrecacheHooks[0]
+	if hook.Command != "npm run generate" || hook.Dir != "packages/app" || hook.Timeout != 5*time.Minute || hook.Env["NODE_OPTIONS"] != "--max-old-space-size=4096" {
+		t.Errorf("Unexpected hook %+v", hook)
+	}
+	if !hook.affectsKey() || options.PrecacheHooks[1].affectsKey() {
+		t.Errorf("Unexpected affectsKey, got %v and %v", hook.affectsKey(), options.PrecacheHooks[1].affectsKey())
+	}
+}
+
+func TestHookKeyInputs(t *testing.T) {
+	affectsKey := false
+	hooks := []Hook{
+		{Command: "npm run build", Env: map[string]string{"B": "2", "A": "1"}},
+		{Command: "notify", AffectsKey: &affectsKey},
+	}
+	inputs, err := hookKeyInputs(hooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 1 || inputs[0].Name != inputs[0].Hash[:hookNameLength] {
+		t.Fatalf("Was expecting only the first hook to affect the key, named by its hash, got %v", inputs)
+	}
+
+	named := hooks[0]
+	named.Name = "build"
+	if input, err := named.keyInput(); err != nil || input.Name != "build" || input.Hash != inputs[0].Hash {
+		t.Errorf("Was expecting the hook to be named build, got %v, %v", input, err)
+	}
+
+	withTimeout := hooks[0]
+	withTimeout.Timeout = time.Minute
+	inDir := hooks[0]
+	inDir.Dir = "packages/app"
+	for name, hook := range map[string]Hook{"timeout": withTimeout, "dir": inDir} {
+		input, err := hook.keyInput()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed := input.Hash != inputs[0].Hash; changed != (name == "dir") {
+			t.Errorf("Changing %s changed key input: %v", name, changed)
+		}
+	}
+}
+
+func TestRunPreCacheCommandRunsHooks(t *testing.T) {
+	runner := &cmd.SpyRunner{}
+	m := &main{
+		options: &Options{
+			PrecacheCommand: "npm run build",
+			PrecacheHooks: []Hook{
+				{Command: "npm run generate", Dir: "packages/app", Env: map[string]string{"B": "2", "A": "1"}},
+			},
+		},
+		installer: NewNpmInstaller(&Config{runner: runner}, hclog.NewNullLogger()),
+		report:    newReport(),
+		log:       hclog.NewNullLogger(),
+	}
+
+	if err := m.runPreCacheCommand(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := runner.RunShellCommandCalls
+	if len(calls) != 2 || calls[0].CommandLine != "npm run build" || calls[1].CommandLine != "npm run generate" {
+		t.Fatalf("Was expecting the precache command followed by the hook, got %v", calls)
+	}
+	if calls[1].Options.Dir != "packages/app" || !slices.Equal(calls[1].Options.Env, []string{"A=1", "B=2"}) {
+		t.Errorf("Unexpected options for hook: %+v", *calls[1].Options)
+	}
+}
@@ -0,0 +1,250 @@
+package npmi
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hermo/npmi-go/pkg/cache"
+	"github.com/hermo/npmi-go/pkg/cmd"
+)
+
+// Installer installs the packages of a project from a cache or using npm.
+// It is the API for embedding npmi-go in other Go programs.
+type Installer struct {
+	m *main
+}
+
+// Result describes the outcome of an installation
+type Result struct {
+	// Project is the project identifier prefixed to the cache key
+	Project string
+	// CacheKey is the key the packages were looked up and stored with
+	CacheKey      string
+	KeyComponents KeyComponents
+	// InstalledFromCache is true on a cache hit
+	InstalledFromCache bool
+	// Source is the name of the cache the packages were installed from
+	Source string
+	// DerivedFrom is the key of the development tree a production tree was derived from
+	DerivedFrom    string
+	Caches         []CacheReport
+	ArchiveSize    int64
+	FilesExtracted int
+	FilesRemoved   int
+	Duration       time.Duration
+	PhaseDurations map[string]time.Duration
+}
+
+// InstallerOptions configures how an Installer installs the packages. Zero values
+// mean the defaults of the command line.
+type InstallerOptions struct {
+	// Mode is one of ModeAuto, ModeProduction and ModeDevelopment
+	Mode string
+	// Force installs the packages using npm and updates the caches even on a cache hit
+	Force bool
+	// DeriveProd derives a production tree from a cached development tree
+	DeriveProd bool
+	// InstallArgs are extra arguments passed to npm ci
+	InstallArgs []string
+	// InstallCommand is a shell command run instead of npm ci
+	InstallCommand string
+	// PrecacheCommand is run before the packages are stored in the caches, followed by PrecacheHooks
+	PrecacheCommand string
+	PrecacheHooks   []Hook
+	// PostRestoreCommand is run after the packages have been installed from a cache
+	PostRestoreCommand string
+	// KeyFiles are globs of files and KeyEnv names of env variables included in the cache key
+	KeyFiles []string
+	KeyEnv   []string
+	// PlatformDistro includes the OS distribution in the platform of the cache key
+	PlatformDistro bool
+	// Namespace and Project are prefixed to the cache key. Project may be ProjectAuto.
+	Namespace string
+	Project   string
+	// LockTimeout limits waiting for another run using the project. Zero waits indefinitely.
+	LockTimeout time.Duration
+	// TempDir is where archives are created
+	TempDir string
+}
+
+// cliOptions returns the command line options corresponding to o
+func (o InstallerOptions) cliOptions() *Options {
+	options := DefaultOptions()
+	if o.Mode != "" {
+		options.Mode = o.Mode
+	}
+	options.Force = o.Force
+	options.DeriveProd = o.DeriveProd
+	options.InstallArgs = o.InstallArgs
+	options.InstallCommand = o.InstallCommand
+	options.PrecacheCommand = o.PrecacheCommand
+	options.PrecacheHooks = o.PrecacheHooks
+	options.PostRestoreCommand = o.PostRestoreCommand
+	options.KeyFiles = o.KeyFiles
+	options.KeyEnv = o.KeyEnv
+	options.PlatformDistro = o.PlatformDistro
+	options.Namespace = o.Namespace
+	options.Project = o.Project
+	options.LockTimeout = o.LockTimeout
+	if o.TempDir != "" {
+		options.TempDir = o.TempDir
+	}
+	return options
+}
+
+// InstallerOption configures an Installer
+type InstallerOption func(*installerSettings)
+
+type installerSettings struct {
+	options    *Options
+	config     *Config
+	caches     []cache.Cacher
+	runner     cmd.Runner
+	projectDir string
+	log        hclog.Logger
+}
+
+// WithOptions configures how the Installer installs the packages
+func WithOptions(options InstallerOptions) InstallerOption {
+	return func(s *installerSettings) {
+		s.options = options.cliOptions()
+	}
+}
+
+// WithCLIOptions configures the Installer using the options of the command line,
+// including the caches enabled in them, instead of DefaultOptions
+func WithCLIOptions(options *Options) InstallerOption {
+	return func(s *installerSettings) {
+		s.options = options
+	}
+}
+
+// WithProjectDir makes the Installer install the packages of the project in dir
+// instead of the current directory
+func WithProjectDir(dir string) InstallerOption {
+	return func(s *installerSettings) {
+		s.projectDir = dir
+	}
+}
+
+// WithCaches makes the Installer use the given caches in order instead of
+// the caches enabled in the options
+func WithCaches(caches ...cache.Cacher) InstallerOption {
+	return func(s *installerSettings) {
+		s.caches = caches
+	}
+}
+
+// WithLogger makes the Installer log using log. By default nothing is logged.
+func WithLogger(log hclog.Logger) InstallerOption {
+	return func(s *installerSettings) {
+		s.log = log
+	}
+}
+
+// WithRunner makes the Installer run node, npm and shell commands using runner.
+// The runner must run them in the project directory.
+func WithRunner(runner cmd.Runner) InstallerOption {
+	return func(s *installerSettings) {
+		s.runner = runner
+	}
+}
+
+// WithNodeConfig makes the Installer use a config built using NewConfigBuilder
+// instead of finding node and npm in PATH. The mode and PlatformDistro of the
+// options take precedence over the ones the config was built with.
+func WithNodeConfig(config *Config) InstallerOption {
+	return func(s *installerSettings) {
+		s.config = config
+	}
+}
+
+// NewInstaller creates an Installer. Unless configured otherwise, the project in the current
+// directory is installed, node and npm are found in PATH and the caches enabled in DefaultOptions
+// are used.
+func NewInstaller(ctx context.Context, opts ...InstallerOption) (*Installer, error) {
+	settings := &installerSettings{
+		options: DefaultOptions(),
+		log:     hclog.NewNullLogger(),
+	}
+	for _, opt := range opts {
+		opt(settings)
+	}
+	if err := validateMode(settings.options.Mode); err != nil {
+		return nil, err
+	}
+	options, err := resolveProject(settings.options, settings.projectDir, settings.log)
+	if err != nil {
+		return nil, err
+	}
+
+	config := settings.config
+	if config == nil {
+		builder := newConfigBuilder(options)
+		builder.WithNodeAndNpmFromPath()
+		builder.WithProjectDir(settings.projectDir)
+		if settings.runner != nil {
+			builder.WithRunner(settings.runner)
+		}
+		if config, err = builder.Build(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		if config, err = config.withOptions(options); err != nil {
+			return nil, err
+		}
+		if settings.runner != nil {
+			config.runner = settings.runner
+		}
+		if settings.projectDir != "" {
+			config.projectDir = settings.projectDir
+		}
+	}
+
+	var caches []cache.Cacher
+	if settings.caches != nil {
+		caches, err = namespaceCaches(settings.caches, cacheNamespace(options))
+	} else {
+		caches, err = initCaches(options, settings.log.Named("cache"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Installer{newMain(options, config, caches, settings.log)}, nil
+}
+
+// Run installs the packages. The result is returned also when the installation fails,
+// describing how far it got.
+func (i *Installer) Run(ctx context.Context) (*Result, error) {
+	err := i.m.Run(ctx)
+	return newResult(i.m.Report()), err
+}
+
+// newResult creates a Result from the report of a run
+func newResult(report *Report) *Result {
+	report.mu.Lock()
+	defer report.mu.Unlock()
+
+	result := &Result{
+		Project:            report.Project,
+		CacheKey:           report.CacheKey,
+		KeyComponents:      report.KeyComponents,
+		InstalledFromCache: report.InstalledFromCache,
+		Source:             report.Source,
+		DerivedFrom:        report.DerivedFrom,
+		ArchiveSize:        report.ArchiveSize,
+		FilesExtracted:     report.FilesExtracted,
+		FilesRemoved:       report.FilesRemoved,
+		Duration:           time.Duration(report.DurationMs) * time.Millisecond,
+		PhaseDurations:     make(map[string]time.Duration, len(report.PhaseDurationsMs)),
+	}
+	for _, c := range report.Caches {
+		result.Caches = append(result.Caches, *c)
+	}
+	for phase, ms := range report.PhaseDurationsMs {
+		result.PhaseDurations[phase] = time.Duration(ms) * time.Millisecond
+	}
+	return result
+}
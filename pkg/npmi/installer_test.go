@@ -0,0 +1,111 @@
+package npmi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hermo/npmi-go/pkg/cache"
+	"github.com/hermo/npmi-go/pkg/cmd"
+	"github.com/hermo/npmi-go/pkg/files"
+)
+
+func TestInstallerRun(t *testing.T) {
+	_, filename, _, _ := runtime.Caller(0)
+	testDataDir := filepath.Join(filepath.Dir(filename), "../../testdata")
+	if !files.DirectoryExists(filepath.Join(testDataDir, "node_modules")) {
+		if err := os.Mkdir(filepath.Join(testDataDir, "node_modules"), 0700); err != nil {
+			t.Fatalf("node_modules not present and mkdir failed: %v", err)
+		}
+	}
+
+	localCache, err := cache.NewLocalCache(t.TempDir(), hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func() *Result {
+		runner := &cmd.SpyRunner{Stdout: "temp-v11.16.3-darwin-x64"}
+		builder := NewConfigBuilder()
+		builder.WithRunner(runner)
+		config, err := builder.Build(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The project is installed in its directory without changing the current directory
+		installer, err := NewInstaller(context.Background(),
+			WithOptions(InstallerOptions{Mode: ModeDevelopment}),
+			WithProjectDir(testDataDir),
+			WithNodeConfig(config),
+			WithCaches(localCache),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := installer.Run(context.Background())
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		return result
+	}
+
+	result := run()
+	if result.InstalledFromCache || result.CacheKey == "" || len(result.Caches) != 1 || result.Caches[0].BytesUploaded == 0 {
+		t.Errorf("Was expecting a cache miss storing the packages, got %+v", result)
+	}
+
+	result = run()
+	if !result.InstalledFromCache || result.Source != "local" || !result.Caches[0].Hit {
+		t.Errorf("Was expecting a cache hit, got %+v", result)
+	}
+	if _, ok := result.PhaseDurations["extract"]; !ok {
+		t.Errorf("Was expecting the duration of the extract phase, got %v", result.PhaseDurations)
+	}
+}
+
+func TestInstallerModeOverridesNodeConfig(t *testing.T) {
+	_, filename, _, _ := runtime.Caller(0)
+	testDataDir := filepath.Join(filepath.Dir(filename), "../../testdata")
+	if !files.DirectoryExists(filepath.Join(testDataDir, "node_modules")) {
+		if err := os.Mkdir(filepath.Join(testDataDir, "node_modules"), 0700); err != nil {
+			t.Fatalf("node_modules not present and mkdir failed: %v", err)
+		}
+	}
+
+	localCache, err := cache.NewLocalCache(t.TempDir(), hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The config is built in development mode
+	t.Setenv("NODE_ENV", "development")
+	builder := NewConfigBuilder()
+	builder.WithRunner(&cmd.SpyRunner{Stdout: "temp-v11.16.3-darwin-x64"})
+	config, err := builder.Build(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	installer, err := NewInstaller(context.Background(),
+		WithOptions(InstallerOptions{Mode: ModeProduction}),
+		WithProjectDir(testDataDir),
+		WithNodeConfig(config),
+		WithCaches(localCache),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := installer.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.KeyComponents.Platform != "temp-v11.16.3-darwin-x64-prod" {
+		t.Errorf("Platform = %s, want temp-v11.16.3-darwin-x64-prod", result.KeyComponents.Platform)
+	}
+	if config.Platform != "temp-v11.16.3-darwin-x64-dev" {
+		t.Errorf("Was not expecting the given config to be modified, got %s", config.Platform)
+	}
+}
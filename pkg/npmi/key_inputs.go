@@ -0,0 +1,120 @@
+package npmi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hermo/npmi-go/pkg/hash"
+)
+
+// KeyInput is an extra input mixed into the cache key
+type KeyInput struct {
+	// Kind is one of "install", "hook", "file" or "env"
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Hash is the SHA-256 of the file contents or the env variable value.
+	// Values of env variables are hashed as they may contain secrets.
+	Hash string `json:"hash"`
+}
+
+// String returns the input in the form mixed into the cache key
+func (i KeyInput) String() string {
+	return fmt.Sprintf("%s:%s:%s", i.Kind, i.Name, i.Hash)
+}
+
+// unsetEnvHash marks an env variable which is not set, to tell it apart from an empty value
+const unsetEnvHash = "unset"
+
+// resolveKeyInputs expands the file globs relative to the project directory dir and looks up the
+// env variables included in the cache key. The inputs are returned in a stable order.
+func resolveKeyInputs(dir string, fileGlobs []string, envNames []string, log hclog.Logger) ([]KeyInput, error) {
+	var inputs []KeyInput
+
+	files := map[string]bool{}
+	for _, pattern := range fileGlobs {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key file pattern %q: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			log.Debug("No files matched key file pattern", "pattern", pattern)
+		}
+		for _, match := range matches {
+			if dir != "" {
+				if match, err = filepath.Rel(dir, match); err != nil {
+					return nil, err
+				}
+			}
+			files[filepath.ToSlash(filepath.Clean(match))] = true
+		}
+	}
+
+	fileNames := make([]string, 0, len(files))
+	for name := range files {
+		fileNames = append(fileNames, name)
+	}
+	sort.Strings(fileNames)
+
+	for _, name := range fileNames {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("can't stat key file: %v", err)
+		}
+		if info.IsDir() {
+			log.Debug("Skipping directory matched by key file pattern", "dir", name)
+			continue
+		}
+		fileHash, err := hash.File(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("can't hash key file: %v", err)
+		}
+		inputs = append(inputs, KeyInput{Kind: "file", Name: name, Hash: fileHash})
+	}
+
+	envs := map[string]bool{}
+	for _, name := range envNames {
+		envs[name] = true
+	}
+	sortedEnvs := make([]string, 0, len(envs))
+	for name := range envs {
+		sortedEnvs = append(sortedEnvs, name)
+	}
+	sort.Strings(sortedEnvs)
+
+	for _, name := range sortedEnvs {
+		envHash := unsetEnvHash
+		if value, ok := os.LookupEnv(name); ok {
+			var err error
+			if envHash, err = hash.String(value); err != nil {
+				return nil, fmt.Errorf("can't hash env variable %s: %v", name, err)
+			}
+		}
+		inputs = append(inputs, KeyInput{Kind: "env", Name: name, Hash: envHash})
+	}
+	return inputs, nil
+}
+
+// installKeyInputs returns the custom install arguments and command as key inputs,
+// so different install modes don't share cache entries
+func installKeyInputs(installArgs []string, installCommand string) ([]KeyInput, error) {
+	var inputs []KeyInput
+	if len(installArgs) > 0 {
+		argsHash, err := hash.String(strings.Join(installArgs, "\x00"))
+		if err != nil {
+			return nil, fmt.Errorf("can't hash install args: %v", err)
+		}
+		inputs = append(inputs, KeyInput{Kind: "install", Name: "args", Hash: argsHash})
+	}
+	if installCommand != "" {
+		commandHash, err := hash.String(installCommand)
+		if err != nil {
+			return nil, fmt.Errorf("can't hash install command: %v", err)
+		}
+		inputs = append(inputs, KeyInput{Kind: "install", Name: "command", Hash: commandHash})
+	}
+	return inputs, nil
+}
@@ -0,0 +1,93 @@
+package npmi
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestResolveKeyInputs(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if err := os.Mkdir("patches", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{".npmrc", "patches/b.patch", "patches/a.patch"} {
+		if err := os.WriteFile(name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("NPMI_TEST_KEY_ENV", "value")
+
+	inputs, err := resolveKeyInputs(
+		"",
+		[]string{"patches/*.patch", ".npmrc", "patches/a.patch", "missing/*"},
+		[]string{"NPMI_TEST_KEY_ENV", "NPMI_TEST_KEY_ENV_UNSET"},
+		hclog.NewNullLogger(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"file:.npmrc", "file:patches/a.patch", "file:patches/b.patch", "env:NPMI_TEST_KEY_ENV", "env:NPMI_TEST_KEY_ENV_UNSET"}
+	if len(inputs) != len(want) {
+		t.Fatalf("Was expecting %d inputs but got %v", len(want), inputs)
+	}
+	for i, input := range inputs {
+		if got := input.Kind + ":" + input.Name; got != want[i] {
+			t.Errorf("Was expecting input %d to be %s but got %s", i, want[i], got)
+		}
+	}
+	if inputs[4].Hash != unsetEnvHash {
+		t.Errorf("Was expecting unset env variable to have hash %q but got %q", unsetEnvHash, inputs[4].Hash)
+	}
+
+	before := inputs[0].Hash
+	if err := os.WriteFile(filepath.Join(dir, ".npmrc"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	inputs, err = resolveKeyInputs("", []string{".npmrc"}, nil, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inputs[0].Hash == before {
+		t.Error("Was expecting the hash to change with the file contents")
+	}
+}
+
+func TestInstallKeyInputs(t *testing.T) {
+	inputs, err := installKeyInputs(nil, "")
+	if err != nil || len(inputs) != 0 {
+		t.Errorf("Was expecting no inputs, got %v, %v", inputs, err)
+	}
+
+	a, err := installKeyInputs([]string{"--omit=dev"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := installKeyInputs([]string{"--ignore-scripts"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 1 || len(b) != 1 || a[0].String() == b[0].String() {
+		t.Errorf("Was expecting different install args to result in different inputs, got %v and %v", a, b)
+	}
+
+	inputs, err = installKeyInputs([]string{"--omit=dev"}, "pnpm install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 2 || inputs[0].Name != "args" || inputs[1].Name != "command" {
+		t.Errorf("Was expecting args and command inputs, got %v", inputs)
+	}
+}
@@ -1,15 +1,23 @@
 package npmi
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hermo/npmi-go/pkg/archive"
 	"github.com/hermo/npmi-go/pkg/cache"
+	"github.com/hermo/npmi-go/pkg/cmd"
 	"github.com/hermo/npmi-go/pkg/files"
 	"github.com/hermo/npmi-go/pkg/hash"
+	"github.com/hermo/npmi-go/pkg/lock"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var (
@@ -21,65 +29,173 @@ var (
 const (
 	defaultModulesDirectory = "node_modules"
 	defaultLockFile         = "package-lock.json"
+	defaultOsReleaseFile    = "/etc/os-release"
+	buildLockPollInterval   = 5 * time.Second
 )
 
 type main struct {
-	caches           []cache.Cacher
-	installer        *NpmInstaller
+	caches    []cache.Cacher
+	installer *NpmInstaller
+	// dir is the project directory, empty for the current directory
+	dir              string
 	lockFile         string
 	modulesDirectory string
 	options          *Options
 	platform         string
+	nodeVersion      string
+	productionMode   bool
+	report           *Report
 	log              hclog.Logger
 }
 
 // New builds a configuration for the current runtime and returns a pre-configured NPMI main
-func New(options *Options, log hclog.Logger) (*main, error) {
-	builder := NewConfigBuilder()
+func New(ctx context.Context, options *Options, log hclog.Logger) (*main, error) {
+	builder := newConfigBuilder(options)
 	builder.WithNodeAndNpmFromPath()
-	config, err := builder.Build()
+	config, err := builder.Build(ctx)
 	if err != nil {
 		return nil, err
 	}
 	return NewWithConfig(options, config, log)
 }
 
+// validateMode checks that mode is one of the supported install modes
+func validateMode(mode string) error {
+	switch mode {
+	case "", ModeAuto, ModeProduction, ModeDevelopment:
+		return nil
+	default:
+		return fmt.Errorf("invalid mode '%s', must be one of %s|%s|%s", mode, ModeAuto, ModeProduction, ModeDevelopment)
+	}
+}
+
+// newConfigBuilder creates a config builder applying the platform and mode options
+func newConfigBuilder(options *Options) *configBuilder {
+	builder := NewConfigBuilder()
+	if options.PlatformDistro {
+		builder.WithOsDistribution(defaultOsReleaseFile)
+	}
+	switch options.Mode {
+	case ModeProduction:
+		builder.WithProductionModeDeterminatorFunc(func() bool { return true })
+	case ModeDevelopment:
+		builder.WithProductionModeDeterminatorFunc(func() bool { return false })
+	}
+	return builder
+}
+
 // NewWithConfig creates a NPMI main using the supplied options and config
 func NewWithConfig(options *Options, config *Config, log hclog.Logger) (*main, error) {
+	if err := validateMode(options.Mode); err != nil {
+		return nil, err
+	}
+	options, err := resolveProject(options, config.projectDir, log)
+	if err != nil {
+		return nil, err
+	}
+
 	caches, err := initCaches(options, log.Named("cache"))
 	if err != nil {
 		return nil, fmt.Errorf("cache init error: %v", err)
 	}
+	return newMain(options, config, caches, log), nil
+}
+
+// newMain creates a NPMI main using the supplied options, config and caches
+func newMain(options *Options, config *Config, caches []cache.Cacher, log hclog.Logger) *main {
+	installer := NewNpmInstaller(config, log.Named("npmInstaller"))
+	installer.SetInstallArgs(options.InstallArgs)
+	installer.SetInstallCommand(options.InstallCommand)
+	installer.SetMode(options.Mode)
+	if options.Stream {
+		installer.SetRunner(cmd.NewStreamingRunner(log.Named("output"), options.StreamTail, config.runOptions()...))
+	}
 
 	return &main{
+		dir:              config.projectDir,
 		modulesDirectory: defaultModulesDirectory,
 		lockFile:         defaultLockFile,
 		options:          options,
 		log:              log,
 		platform:         config.Platform,
-		installer:        NewNpmInstaller(config, log.Named("npmInstaller")),
+		nodeVersion:      config.nodeVersion,
+		productionMode:   config.productionMode,
+		installer:        installer,
 		caches:           caches,
-	}, nil
+		report:           newReport(),
+	}
+}
+
+// Run determines and performs the steps required to install the desired dependencies.
+// Cancelling ctx stops the installation, terminates any running child processes and
+// removes temporary files.
+func (m *main) Run(ctx context.Context) error {
+	m.report = newReport()
+	ctx, span := startSpan(ctx, "npmi-go", attribute.String("npmi.version", Version))
+	err := m.run(ctx)
+	m.report.finish(err)
+	span.SetAttributes(
+		attribute.String("npmi.cache_key", m.report.CacheKey),
+		attribute.Bool("npmi.installed_from_cache", m.report.InstalledFromCache),
+		attribute.String("npmi.source", m.report.Source),
+	)
+	endSpan(span, err)
+
+	if metricsErr := m.exportMetrics(ctx); metricsErr != nil {
+		m.log.Warn("Could not export metrics", "error", metricsErr)
+	}
+
+	if m.options.ReportFile != "" {
+		if reportErr := m.report.WriteFile(m.options.ReportFile); reportErr != nil {
+			m.log.Error("Could not write report", "path", m.options.ReportFile, "error", reportErr)
+			if err == nil {
+				err = reportErr
+			}
+		} else {
+			m.log.Debug("Report written", "path", m.options.ReportFile)
+		}
+	}
+	return err
+}
+
+// Report returns the report of the latest run
+func (m *main) Report() *Report {
+	return m.report
 }
 
-// Run determines and performs the steps required to install the desired dependencies
-func (m *main) Run() error {
+func (m *main) run(ctx context.Context) error {
 	m.log.Info("Starting installation", "version", Version)
 
 	if m.options.Verbose {
 		m.log.Warn("-verbose and NPMI_VERBOSE are deprecated. Please use the -loglevel flag or NPMI_LOGLEVEL env variable with 'debug' or 'trace'")
 	}
+	projectLock, err := m.lockProject(ctx)
+	if err != nil {
+		return err
+	}
+	defer projectLock.Release()
+
 	cacheKey, err := m.createCacheKey()
 	if err != nil {
 		return err
 	}
 
-	installedFromCache, err := m.tryToInstallFromCache(cacheKey)
+	installedFromCache, err := m.tryToInstallFromCache(ctx, cacheKey, false)
 	if err != nil {
 		return err
 	}
 
+	if !installedFromCache && !m.options.Force {
+		var unlock func()
+		installedFromCache, unlock, err = m.waitForConcurrentBuild(ctx, cacheKey)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
 	isInstallationFromNpmRequired := m.options.Force || !installedFromCache
+	restoredFromCache := !isInstallationFromNpmRequired
 
 	if isInstallationFromNpmRequired {
 		log := m.log.Named("install")
@@ -88,14 +204,32 @@ func (m *main) Run() error {
 			log.Warn("Package found in cache, force install is enabled")
 		}
 
-		err = m.installFromNpm()
+		derived := false
+		if m.options.DeriveProd && !m.options.Force {
+			derived, err = m.deriveFromDevelopmentTree(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !derived {
+			err = m.installFromNpm(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = m.cacheInstalledPackages(ctx, cacheKey)
 		if err != nil {
 			return err
 		}
+		restoredFromCache = derived
+	}
 
-		err = m.cacheInstalledPackages(cacheKey)
+	if restoredFromCache {
+		err = m.runPostRestoreCommand(ctx)
 		if err != nil {
-			return err
+			return fmt.Errorf("postRestore: %v", err)
 		}
 	}
 
@@ -104,18 +238,223 @@ func (m *main) Run() error {
 	return nil
 }
 
-func (m *main) createCacheKey() (string, error) {
-	lockFileHash, err := hash.File(m.lockFile)
+// path returns the path of a file in the project directory
+func (m *main) path(name string) string {
+	return filepath.Join(m.dir, name)
+}
+
+// tarOptions returns the options archives of the project are created and extracted with
+func (m *main) tarOptions() *archive.TarOptions {
+	return &archive.TarOptions{
+		AllowAbsolutePaths:   m.options.TarAbsolutePaths,
+		AllowDoubleDotPaths:  m.options.TarDoubleDotPaths,
+		AllowLinksOutsideCwd: m.options.TarLinksOutsideCwd,
+		Dir:                  m.dir,
+	}
+}
+
+// startPhase starts a phase of the run, timing it for the report and tracing it as a span.
+// The returned func ends the phase.
+func (m *main) startPhase(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+	endTiming := m.report.startPhase(name)
+	ctx, span := startSpan(ctx, name, attrs...)
+	return ctx, func(err error) {
+		endTiming()
+		endSpan(span, err)
+	}
+}
+
+// lockProject prevents concurrent runs from modifying the same project directory
+func (m *main) lockProject(ctx context.Context) (*lock.FileLock, error) {
+	log := m.log.Named("lock")
+
+	wd, err := filepath.Abs(m.dir)
 	if err != nil {
-		return "", fmt.Errorf("can't hash lockfile: %v", err)
+		return nil, err
+	}
+	wdHash, err := hash.String(wd)
+	if err != nil {
+		return nil, err
 	}
 
-	cacheKey, err := cache.CreateKey(m.platform, lockFileHash, m.options.PrecacheCommand)
+	tempDir := m.options.TempDir
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	lockPath := filepath.Join(tempDir, fmt.Sprintf("npmi-go-%s.lock", wdHash[:16]))
+	log.Trace("start", "path", lockPath)
+
+	projectLock, err := lock.TryAcquire(lockPath)
+	if err == nil && projectLock == nil {
+		log.Info("Another npmi-go run is using this project, waiting", "path", lockPath)
+		if m.options.LockTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, m.options.LockTimeout)
+			defer cancel()
+		}
+		projectLock, err = lock.Acquire(ctx, lockPath)
+	}
+	if err != nil {
+		log.Error("failed", "error", err)
+		return nil, fmt.Errorf("could not lock project directory: %v", err)
+	}
+
+	log.Trace("complete")
+	return projectLock, nil
+}
+
+// waitForConcurrentBuild takes the build lock of a key in caches supporting it.
+// If another client is already building the key, it waits until the result
+// appears in that cache and installs the packages from there.
+func (m *main) waitForConcurrentBuild(ctx context.Context, cacheKey string) (installedFromCache bool, unlock func(), err error) {
+	log := m.log.Named("buildLock")
+
+	var unlocks []func() error
+	unlock = func() {
+		for _, u := range unlocks {
+			if err := u(); err != nil {
+				log.Warn("Could not release build lock", "error", err)
+			}
+		}
+	}
+
+	for _, c := range m.caches {
+		locker, ok := c.(cache.Locker)
+		if !ok {
+			continue
+		}
+
+		cLog := log.Named(fmt.Sprint(c))
+		for {
+			release, acquired, err := locker.TryLock(ctx, cacheKey)
+			if err != nil {
+				unlock()
+				return false, nil, err
+			}
+			if acquired {
+				unlocks = append(unlocks, release)
+				break
+			}
+
+			cLog.Info("Another client is building this key, waiting", "cacheKey", cacheKey)
+			select {
+			case <-ctx.Done():
+				unlock()
+				return false, nil, ctx.Err()
+			case <-time.After(buildLockPollInterval):
+			}
+
+			found, err := c.Has(ctx, cacheKey)
+			if err != nil {
+				unlock()
+				return false, nil, err
+			}
+			if found {
+				cLog.Debug("Key built by another client")
+				unlock()
+				installedFromCache, err = m.tryToInstallFromCache(ctx, cacheKey, false)
+				return installedFromCache, func() {}, err
+			}
+		}
+	}
+	return false, unlock, nil
+}
+
+func (m *main) createCacheKey() (string, error) {
+	cacheKey, components, err := m.buildCacheKey(m.platform)
 	if err != nil {
 		return "", err
 	}
+
+	m.report.Project = m.options.Project
+	m.report.CacheKey = cacheKey
+	m.report.KeyComponents = *components
 	return cacheKey, nil
+}
 
+// buildCacheKey creates the cache key of the project for the given platform
+func (m *main) buildCacheKey(platform string) (string, *KeyComponents, error) {
+	lockFileHash, err := hash.File(m.path(m.lockFile))
+	if err != nil {
+		return "", nil, fmt.Errorf("can't hash lockfile: %v", err)
+	}
+
+	log := m.log.Named("key")
+	inputs, err := resolveKeyInputs(m.dir, m.options.KeyFiles, m.options.KeyEnv, log)
+	if err != nil {
+		return "", nil, err
+	}
+	installInputs, err := installKeyInputs(m.options.InstallArgs, m.options.InstallCommand)
+	if err != nil {
+		return "", nil, err
+	}
+	// The precache command is part of the key itself for backwards compatibility
+	hookInputs, err := hookKeyInputs(m.options.PrecacheHooks)
+	if err != nil {
+		return "", nil, err
+	}
+	inputs = append(append(installInputs, hookInputs...), inputs...)
+
+	extraInputs := make([]string, len(inputs))
+	for i, input := range inputs {
+		log.Debug("Cache key input", "kind", input.Kind, "name", input.Name, "hash", input.Hash)
+		extraInputs[i] = input.String()
+	}
+
+	cacheKey, err := cache.CreateKey(platform, lockFileHash, m.options.PrecacheCommand, extraInputs...)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return cacheKey, &KeyComponents{
+		Platform:        platform,
+		LockFileHash:    lockFileHash,
+		PrecacheCommand: m.options.PrecacheCommand,
+		Inputs:          inputs,
+	}, nil
+}
+
+// deriveFromDevelopmentTree installs the development tree of the project from cache and
+// removes dev deps from it, avoiding a full install of the production tree.
+// It returns false if the development tree is not cached.
+func (m *main) deriveFromDevelopmentTree(ctx context.Context) (bool, error) {
+	log := m.log.Named("derive")
+	if !m.productionMode {
+		return false, nil
+	}
+	if m.options.InstallCommand != "" {
+		log.Warn("Deriving the production tree is not supported with a custom install command")
+		return false, nil
+	}
+
+	devPlatform := strings.TrimSuffix(m.platform, "-prod") + "-dev"
+	devKey, _, err := m.buildCacheKey(devPlatform)
+	if err != nil {
+		return false, err
+	}
+
+	log.Trace("start", "devCacheKey", devKey)
+	found, err := m.tryToInstallFromCache(ctx, devKey, true)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		log.Debug("Development tree not found in cache")
+		return false, nil
+	}
+
+	pruneCtx, endPhase := m.startPhase(ctx, "prune")
+	stdout, stderr, err := m.installer.Prune(pruneCtx)
+	endPhase(err)
+	if err != nil {
+		log.Error("prune failed", "error", err, "stderr", hclog.Quote(stderr))
+		return false, err
+	}
+
+	log.Trace("complete", "stdout", hclog.Quote(stdout))
+	log.Info("Production tree derived from cached development tree")
+	m.report.DerivedFrom = devKey
+	return true, nil
 }
 
 func initCaches(options *Options, log hclog.Logger) ([]cache.Cacher, error) {
@@ -136,17 +475,47 @@ func initCaches(options *Options, log hclog.Logger) ([]cache.Cacher, error) {
 		caches = append(caches, cache)
 	}
 
+	if options.UseHTTPCache {
+		cache, err := cache.NewHTTPCache(options.HTTPCache.URL, options.HTTPCache.Token, log.Named("http"))
+		if err != nil {
+			return nil, fmt.Errorf("http cache: %s", err)
+		}
+		caches = append(caches, cache)
+	}
+
+	if options.UseExecCache {
+		cache, err := cache.NewExecCache(options.ExecCache.Command, options.ExecCache.Args, log.Named("exec"))
+		if err != nil {
+			return nil, fmt.Errorf("exec cache: %s", err)
+		}
+		caches = append(caches, cache)
+	}
+
 	if len(caches) == 0 {
 		log.Warn("No caches configured, no caching will be performed!")
 	}
-	return caches, nil
+	return namespaceCaches(caches, cacheNamespace(options))
+}
+
+// namespaceCaches wraps caches so that keys are validated and stored under the namespace
+func namespaceCaches(caches []cache.Cacher, namespace string) ([]cache.Cacher, error) {
+	namespaced := make([]cache.Cacher, len(caches))
+	for i, c := range caches {
+		var err error
+		if namespaced[i], err = cache.NewNamespacedCache(c, namespace); err != nil {
+			return nil, err
+		}
+	}
+	return namespaced, nil
 }
 
-func (m *main) installFromNpm() error {
+func (m *main) installFromNpm(ctx context.Context) error {
 	log := m.log.Named("installPackages")
 	log.Trace("start")
 
-	stdout, stderr, err := m.installer.Run()
+	installCtx, endPhase := m.startPhase(ctx, "install")
+	stdout, stderr, err := m.installer.Run(installCtx)
+	endPhase(err)
 	if err != nil {
 		log.Error("failed", "error", err, "stderr", hclog.Quote(stderr))
 		return err
@@ -154,11 +523,11 @@ func (m *main) installFromNpm() error {
 
 	log.Trace("complete", "stdout", hclog.Quote(stdout))
 
-	if !files.DirectoryExists(m.modulesDirectory) {
+	if !files.DirectoryExists(m.path(m.modulesDirectory)) {
 		return fmt.Errorf("modules directory '%s' not present after NPM install", m.modulesDirectory)
 	}
 
-	err = m.runPreCacheCommand()
+	err = m.runPreCacheCommand(ctx)
 	if err != nil {
 		return fmt.Errorf("preCache: %v: %s", err, stderr)
 	}
@@ -167,14 +536,14 @@ func (m *main) installFromNpm() error {
 	return nil
 }
 
-func (m *main) cacheInstalledPackages(cacheKey string) error {
-	archiveFilename, err := m.createArchive(cacheKey)
+func (m *main) cacheInstalledPackages(ctx context.Context, cacheKey string) error {
+	archiveFilename, manifest, err := m.createArchive(ctx, cacheKey)
 	if err != nil {
 		return fmt.Errorf("createArchive: %v", err)
 	}
 	defer m.removeArchiveAfterCaching(archiveFilename)
 
-	err = m.storeArchiveInCache(cacheKey, archiveFilename)
+	err = m.storeArchiveInCache(ctx, cacheKey, archiveFilename, entryMetadata(manifest))
 	if err != nil {
 		return fmt.Errorf("cacheArchive: %v", err)
 	}
@@ -192,39 +561,78 @@ func (m *main) removeArchiveAfterCaching(archiveFilename string) {
 	log.Debug("Removed temporary archive", "path", archiveFilename)
 }
 
-func (m *main) createArchive(cacheKey string) (archiveFilename string, err error) {
+func (m *main) createArchive(ctx context.Context, cacheKey string) (archiveFilename string, manifest *archive.Manifest, err error) {
 	log := m.log.Named("createArchive")
 	log.Trace("start")
+	ctx, endPhase := m.startPhase(ctx, "archive")
+	defer func() { endPhase(err) }()
 
 	archivePath := filepath.Join(m.options.TempDir, createArchiveFilename(cacheKey))
 	log.Debug("Creating archive", "path", archivePath)
 
-	tarOptions := archive.TarOptions{
-		AllowAbsolutePaths:   m.options.TarAbsolutePaths,
-		AllowDoubleDotPaths:  m.options.TarDoubleDotPaths,
-		AllowLinksOutsideCwd: m.options.TarLinksOutsideCwd,
+	manifest, err = m.newArchiveManifest(ctx, cacheKey)
+	if err != nil {
+		return "", nil, err
 	}
-	warnings, err := archive.Create(archivePath, m.modulesDirectory, &tarOptions)
+	warnings, err := archive.Create(ctx, archivePath, m.modulesDirectory, manifest, m.tarOptions())
 	if err != nil {
 		log.Error("failed", "error", err)
-		return "", err
+		return "", nil, err
 	}
 
 	for _, warning := range warnings {
 		log.Warn(warning)
 	}
 
+	if info, err := os.Stat(archivePath); err == nil {
+		m.report.ArchiveSize = info.Size()
+		log.Debug("Archive created", "size", info.Size())
+	}
+
 	log.Trace("complete")
-	return archivePath, nil
+	return archivePath, manifest, nil
+}
+
+// newArchiveManifest describes what created an archive. The entries of the archive are added by archive.Create.
+func (m *main) newArchiveManifest(ctx context.Context, cacheKey string) (*archive.Manifest, error) {
+	keyComponents, err := json.Marshal(m.report.KeyComponents)
+	if err != nil {
+		return nil, err
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		m.log.Debug("Could not determine hostname", "error", err)
+	}
+
+	npmVersion, _, err := m.installer.Version(ctx)
+	if err != nil {
+		m.log.Debug("Could not determine npm version", "error", err)
+		npmVersion = ""
+	}
+
+	return &archive.Manifest{
+		NpmiVersion:   Version,
+		Project:       m.options.Project,
+		CreatedAt:     time.Now().UTC(),
+		Host:          host,
+		CIJobURL:      ciJobURL(),
+		NodeVersion:   m.nodeVersion,
+		NpmVersion:    npmVersion,
+		CacheKey:      cacheKey,
+		KeyComponents: keyComponents,
+	}, nil
 }
 
 func createArchiveFilename(cacheKey string) string {
 	return fmt.Sprintf("modules-%s.tar.gz", cacheKey)
 }
 
-func (m *main) storeArchiveInCache(cacheKey string, archiveFilename string) error {
+func (m *main) storeArchiveInCache(ctx context.Context, cacheKey string, archiveFilename string, metadata cache.Metadata) (err error) {
 	log := m.log.Named("cacheArchive")
 	log.Trace("start")
+	ctx, endPhase := m.startPhase(ctx, "upload")
+	defer func() { endPhase(err) }()
 
 	archiveFile, err := os.Open(archiveFilename)
 	if err != nil {
@@ -232,15 +640,16 @@ func (m *main) storeArchiveInCache(cacheKey string, archiveFilename string) erro
 	}
 	defer archiveFile.Close()
 
-	for _, cache := range m.caches {
-		cLog := log.Named(fmt.Sprint(cache))
+	for _, c := range m.caches {
+		cLog := log.Named(fmt.Sprint(c))
 		_, err := archiveFile.Seek(0, 0)
 		if err != nil {
 			cLog.Error("Archive seek failed", "error", err)
 			return err
 		}
 		cLog.Trace("start")
-		err = cache.Put(cacheKey, archiveFile)
+		cacheReport := m.report.cache(fmt.Sprint(c))
+		err = cache.PutWithMetadata(ctx, c, cacheKey, &countingReader{archiveFile, &cacheReport.BytesUploaded}, metadata)
 		if err != nil {
 			cLog.Error("Put failed", "error", err)
 			return err
@@ -253,18 +662,58 @@ func (m *main) storeArchiveInCache(cacheKey string, archiveFilename string) erro
 	return nil
 }
 
-func (m *main) runPreCacheCommand() error {
-	if m.options.PrecacheCommand == "" {
+func (m *main) runPreCacheCommand(ctx context.Context) (err error) {
+	hooks := m.precacheHooks()
+	if len(hooks) == 0 {
 		return nil
 	}
 
 	log := m.log.Named("preCache")
 
 	log.Trace("start")
+	ctx, endPhase := m.startPhase(ctx, "precache")
+	defer func() { endPhase(err) }()
 
-	stdout, stderr, err := m.installer.RunPrecacheCommand(m.options.PrecacheCommand)
+	for _, hook := range hooks {
+		hookCtx, cancel := hook.withTimeout(ctx)
+		stdout, stderr, err := m.installer.RunPrecacheCommand(hookCtx, hook.Command, hook.runOptions()...)
+		cancel()
+		if err != nil {
+			log.Error("Precache command failed", "command", hclog.Quote(hook.Command), "error", err, "stderr", hclog.Quote(stderr))
+			return err
+		}
+		log.Trace("Precache command complete", "command", hclog.Quote(hook.Command), "stdout", hclog.Quote(stdout))
+	}
+
+	log.Trace("complete")
+	return nil
+}
+
+// precacheHooks returns the precache command followed by the precache hooks
+func (m *main) precacheHooks() []Hook {
+	var hooks []Hook
+	if m.options.PrecacheCommand != "" {
+		hooks = append(hooks, Hook{Command: m.options.PrecacheCommand})
+	}
+	return append(hooks, m.options.PrecacheHooks...)
+}
+
+// runPostRestoreCommand runs the post-restore command after packages have been restored from cache.
+// Changes made by it are not stored in cache.
+func (m *main) runPostRestoreCommand(ctx context.Context) (err error) {
+	if m.options.PostRestoreCommand == "" {
+		return nil
+	}
+
+	log := m.log.Named("postRestore")
+
+	log.Trace("start")
+	ctx, endPhase := m.startPhase(ctx, "postrestore")
+	defer func() { endPhase(err) }()
+
+	stdout, stderr, err := m.installer.RunPostRestoreCommand(ctx, m.options.PostRestoreCommand)
 	if err != nil {
-		log.Error("Precache command failed", "command", hclog.Quote(m.options.PrecacheCommand), "error", err, "stderr", hclog.Quote(stderr))
+		log.Error("Post-restore command failed", "command", hclog.Quote(m.options.PostRestoreCommand), "error", err, "stderr", hclog.Quote(stderr))
 		return err
 	}
 
@@ -272,17 +721,24 @@ func (m *main) runPreCacheCommand() error {
 	return nil
 }
 
-func (m *main) tryToInstallFromCache(cacheKey string) (foundInCache bool, err error) {
+// tryToInstallFromCache installs the packages of a key from the first cache having it. If derive
+// is set, the packages are the base of a derived production tree, which is not reported as
+// installed from cache.
+func (m *main) tryToInstallFromCache(ctx context.Context, cacheKey string, derive bool) (foundInCache bool, err error) {
 	log := m.log.Named("cache")
 	log.Trace("start", "cacheKey", cacheKey)
 
 	foundInCache = false
 	for _, cache := range m.caches {
 		cLog := log.Named(fmt.Sprint(cache))
+		cacheReport := m.report.cache(fmt.Sprint(cache))
 		lookupLog := cLog.Named("lookup")
 		lookupLog.Trace("start")
 
-		foundInCache, err = cache.Has(cacheKey)
+		cacheAttr := attribute.String("npmi.cache", fmt.Sprint(cache))
+		phaseCtx, endPhase := m.startPhase(ctx, "lookup", cacheAttr)
+		foundInCache, err = cache.Has(phaseCtx, cacheKey)
+		endPhase(err)
 		if err != nil {
 			lookupLog.Error("failed", "error", err)
 			return false, err
@@ -297,31 +753,47 @@ func (m *main) tryToInstallFromCache(cacheKey string) (foundInCache bool, err er
 
 		lookupLog.Trace("complete")
 		lookupLog.Debug("cache HIT")
+		if derive {
+			cacheReport.DerivedHit = true
+		} else {
+			cacheReport.Hit = true
+		}
 
 		fetchLog := cLog.Named("fetch")
 
 		fetchLog.Trace("start")
-		foundArchive, err := cache.Get(cacheKey)
+		phaseCtx, endPhase = m.startPhase(ctx, "fetch", cacheAttr)
+		fetched, err := cache.Get(phaseCtx, cacheKey)
 		if err != nil {
+			endPhase(err)
 			fetchLog.Error("failed", "error", err)
 			return false, err
 		}
-		fetchLog.Trace("complete")
+		// Caches may fetch the archive while it is read, so the fetch phase lasts until the
+		// archive has been read and overlaps with extracting it
+		endFetch := endPhase
+		foundArchive := &phaseReader{r: &countingReader{fetched, &cacheReport.BytesDownloaded}, end: func(err error) {
+			endFetch(err)
+			if err != nil {
+				fetchLog.Error("failed", "error", err)
+				return
+			}
+			fetchLog.Trace("complete", "bytes", cacheReport.BytesDownloaded)
+		}}
 
 		if m.options.Force {
+			closeArchive(foundArchive)
 			cLog.Debug("Force install requested, skipping Extraction")
 			continue
 		}
 
 		extractLog := cLog.Named("extract")
 		extractLog.Trace("start")
+		phaseCtx, endPhase = m.startPhase(ctx, "extract", cacheAttr)
 
-		tarOptions := archive.TarOptions{
-			AllowAbsolutePaths:   m.options.TarAbsolutePaths,
-			AllowDoubleDotPaths:  m.options.TarDoubleDotPaths,
-			AllowLinksOutsideCwd: m.options.TarLinksOutsideCwd,
-		}
-		archiveManifest, warnings, err := archive.Extract(foundArchive, &tarOptions)
+		archiveManifest, warnings, err := archive.Extract(phaseCtx, foundArchive, m.tarOptions())
+		closeArchive(foundArchive)
+		endPhase(err)
 		for _, warning := range warnings {
 			log.Warn(warning)
 		}
@@ -333,7 +805,13 @@ func (m *main) tryToInstallFromCache(cacheKey string) (foundInCache bool, err er
 		cleanupLog := extractLog.Named("cleanup")
 		cleanupLog.Trace("start")
 
-		filesRemoved, err := files.RemoveFilesNotPresentInManifest(m.modulesDirectory, archiveManifest)
+		_, endPhase = m.startPhase(ctx, "cleanup", cacheAttr)
+		filesToKeep := make([]string, len(archiveManifest))
+		for i, file := range archiveManifest {
+			filesToKeep[i] = m.path(file)
+		}
+		filesRemoved, err := files.RemoveFilesNotPresentInManifest(m.path(m.modulesDirectory), filesToKeep)
+		endPhase(err)
 		if err != nil {
 			cleanupLog.Error("failed", "error", err)
 			return false, err
@@ -342,6 +820,12 @@ func (m *main) tryToInstallFromCache(cacheKey string) (foundInCache bool, err er
 		cleanupLog.Trace("complete", "numFilesRemoved", len(filesRemoved), "filesRemoved", filesRemoved)
 		extractLog.Trace("complete")
 		cLog.Debug("packages successfully installed from cache")
+		if !derive {
+			m.report.InstalledFromCache = true
+			m.report.Source = fmt.Sprint(cache)
+		}
+		m.report.FilesExtracted = len(archiveManifest)
+		m.report.FilesRemoved = len(filesRemoved)
 
 		// Cache hit, no need to look further
 		break
@@ -351,15 +835,39 @@ func (m *main) tryToInstallFromCache(cacheKey string) (foundInCache bool, err er
 	return foundInCache, nil
 }
 
+// closeArchive closes an archive fetched from a cache if it needs closing
+func closeArchive(archive io.Reader) {
+	if closer, ok := archive.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
 func initMinioCache(options *MinioCacheOptions, log hclog.Logger) (cache.Cacher, error) {
 	mLog := log.Named("minio")
-	cache := cache.NewMinioCache(options.Endpoint, options.AccessKeyID, options.SecretAccessKey, options.Bucket, options.UseTLS, options.InsecureTLS, mLog)
-	err := cache.Dial()
+	minio := cache.NewMinioCache(options.Endpoint, options.AccessKeyID, options.SecretAccessKey, options.Bucket, options.UseTLS, options.InsecureTLS, mLog)
+	minio.SetRetryPolicy(minioRetryPolicy(options))
+	minio.SetLockTTL(options.LockTTL)
+	err := minio.Dial()
 	if err != nil {
 		mLog.Error("Dial failed", "error", err)
 		return nil, err
 	}
-	return cache, nil
+	return minio, nil
+}
+
+// minioRetryPolicy builds a retry policy from Minio options, using defaults for unset values.
+// A zero timeout or deadline disables it.
+func minioRetryPolicy(options *MinioCacheOptions) cache.RetryPolicy {
+	policy := cache.DefaultRetryPolicy()
+	policy.OperationTimeout = options.Timeout
+	policy.Deadline = options.Deadline
+	if options.Retries >= 0 {
+		policy.MaxAttempts = options.Retries + 1
+	}
+	if options.RetryBackoff > 0 {
+		policy.InitialBackoff = options.RetryBackoff
+	}
+	return policy
 }
 
 func initLocalCache(options *LocalCacheOptions, log hclog.Logger) (cache.Cacher, error) {
@@ -0,0 +1,67 @@
+package npmi
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+
+	"github.com/hermo/npmi-go/pkg/archive"
+	"github.com/hermo/npmi-go/pkg/cache"
+)
+
+// Names of the metadata stored with cache entries
+const (
+	MetadataNpmiVersion      = "npmi-version"
+	MetadataPlatform         = "platform"
+	MetadataLockFileHash     = "lockfile-hash"
+	MetadataProject          = "project"
+	MetadataHost             = "host"
+	MetadataCIJobURL         = "ci-job-url"
+	MetadataCompression      = "compression"
+	MetadataUncompressedSize = "uncompressed-size"
+)
+
+// entryMetadata describes a cache entry using the manifest of its archive. Empty values are left out.
+func entryMetadata(manifest *archive.Manifest) cache.Metadata {
+	if manifest == nil {
+		return nil
+	}
+	var components KeyComponents
+	if len(manifest.KeyComponents) > 0 {
+		// Archives created elsewhere may describe their key differently
+		_ = json.Unmarshal(manifest.KeyComponents, &components)
+	}
+
+	metadata := cache.Metadata{
+		MetadataCompression:      archive.Compression,
+		MetadataUncompressedSize: strconv.FormatInt(manifest.TotalSize, 10),
+	}
+	for name, value := range map[string]string{
+		MetadataNpmiVersion:  manifest.NpmiVersion,
+		MetadataPlatform:     components.Platform,
+		MetadataLockFileHash: components.LockFileHash,
+		MetadataProject:      manifest.Project,
+		MetadataHost:         manifest.Host,
+		MetadataCIJobURL:     manifest.CIJobURL,
+	} {
+		if value != "" {
+			metadata[name] = value
+		}
+	}
+	return metadata
+}
+
+// ciJobURL returns the URL of the current CI job on GitLab, GitHub Actions, Jenkins,
+// CircleCI or Buildkite, or an empty string outside of CI
+func ciJobURL() string {
+	for _, name := range []string{"CI_JOB_URL", "BUILD_URL", "CIRCLE_BUILD_URL", "BUILDKITE_BUILD_URL"} {
+		if url := os.Getenv(name); url != "" {
+			return url
+		}
+	}
+	server, repository, runID := os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID")
+	if server != "" && repository != "" && runID != "" {
+		return server + "/" + repository + "/actions/runs/" + runID
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package npmi
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hermo/npmi-go/pkg/archive"
+)
+
+func TestEntryMetadata(t *testing.T) {
+	keyComponents, err := json.Marshal(KeyComponents{Platform: "v20.10.0-linux-x64-glibc2.36-abi115-dev", LockFileHash: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := entryMetadata(&archive.Manifest{
+		NpmiVersion:   "1.2.3",
+		Project:       "team-a/myapp",
+		Host:          "build-01",
+		KeyComponents: keyComponents,
+		TotalSize:     1234,
+	})
+
+	want := map[string]string{
+		MetadataNpmiVersion:      "1.2.3",
+		MetadataPlatform:         "v20.10.0-linux-x64-glibc2.36-abi115-dev",
+		MetadataLockFileHash:     "abc",
+		MetadataProject:          "team-a/myapp",
+		MetadataHost:             "build-01",
+		MetadataCompression:      "gzip",
+		MetadataUncompressedSize: "1234",
+	}
+	if len(metadata) != len(want) {
+		t.Errorf("entryMetadata() = %v, want %v", metadata, want)
+	}
+	for name, value := range want {
+		if metadata[name] != value {
+			t.Errorf("%s = %q, want %q", name, metadata[name], value)
+		}
+	}
+	if entryMetadata(nil) != nil {
+		t.Errorf("Was expecting no metadata without a manifest")
+	}
+}
+
+func TestCIJobURL(t *testing.T) {
+	for _, name := range []string{"CI_JOB_URL", "BUILD_URL", "CIRCLE_BUILD_URL", "BUILDKITE_BUILD_URL"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("GITHUB_SERVER_URL", "https://github.com")
+	t.Setenv("GITHUB_REPOSITORY", "hermo/npmi-go")
+	t.Setenv("GITHUB_RUN_ID", "42")
+	if got, want := ciJobURL(), "https://github.com/hermo/npmi-go/actions/runs/42"; got != want {
+		t.Errorf("ciJobURL() = %q, want %q", got, want)
+	}
+	t.Setenv("CI_JOB_URL", "https://gitlab.example.com/group/app/-/jobs/7")
+	if got, want := ciJobURL(), "https://gitlab.example.com/group/app/-/jobs/7"; got != want {
+		t.Errorf("ciJobURL() = %q, want %q", got, want)
+	}
+}
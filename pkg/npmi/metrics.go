@@ -0,0 +1,114 @@
+package npmi
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const defaultMetricsJob = "npmi-go"
+
+// newMetricsRegistry creates a Prometheus registry describing a finished run.
+// Metrics are gauges as they describe a single run; the Pushgateway and textfile
+// collector only ever hold the latest values. constLabels are added to every metric.
+func newMetricsRegistry(report *Report, constLabels prometheus.Labels) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+
+	gauge := func(name string, help string, value float64) {
+		g := prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   "npmi",
+			Name:        name,
+			Help:        help,
+			ConstLabels: constLabels,
+		})
+		g.Set(value)
+		registry.MustRegister(g)
+	}
+	gaugeVec := func(name string, help string, labels ...string) *prometheus.GaugeVec {
+		g := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   "npmi",
+			Name:        name,
+			Help:        help,
+			ConstLabels: constLabels,
+		}, labels)
+		registry.MustRegister(g)
+		return g
+	}
+
+	gauge("run_success", "Whether the run succeeded (1) or failed (0).", boolToFloat(report.Success))
+	gauge("run_timestamp_seconds", "Time the run started at.", float64(report.StartedAt.Unix()))
+	gauge("run_duration_seconds", "Duration of the whole run.", msToSeconds(report.DurationMs))
+	gauge("installed_from_cache", "Whether packages were installed from a cache (1) or NPM (0).", boolToFloat(report.InstalledFromCache))
+	gauge("derived_from_cache", "Whether the production tree was derived from a cached development tree (1) or not (0).", boolToFloat(report.DerivedFrom != ""))
+	gauge("archive_size_bytes", "Size of the archive created for caching.", float64(report.ArchiveSize))
+	gauge("files_extracted", "Number of files extracted from a cached archive.", float64(report.FilesExtracted))
+	gauge("files_removed", "Number of extraneous files removed after extraction.", float64(report.FilesRemoved))
+
+	hits := gaugeVec("cache_hit", "Whether the cache contained the key (1) or not (0).", "cache")
+	downloaded := gaugeVec("cache_downloaded_bytes", "Bytes downloaded from the cache.", "cache")
+	uploaded := gaugeVec("cache_uploaded_bytes", "Bytes uploaded to the cache.", "cache")
+	for _, c := range report.Caches {
+		hits.WithLabelValues(c.Name).Set(boolToFloat(c.Hit))
+		downloaded.WithLabelValues(c.Name).Set(float64(c.BytesDownloaded))
+		uploaded.WithLabelValues(c.Name).Set(float64(c.BytesUploaded))
+	}
+
+	phases := gaugeVec("phase_duration_seconds", "Duration of a phase of the run.", "phase")
+	for phase, ms := range report.PhaseDurationsMs {
+		phases.WithLabelValues(phase).Set(msToSeconds(ms))
+	}
+
+	return registry
+}
+
+// exportMetrics writes the metrics of a finished run to a textfile and/or a Pushgateway
+func (m *main) exportMetrics(ctx context.Context) error {
+	if m.options.MetricsFile == "" && m.options.MetricsPushURL == "" {
+		return nil
+	}
+
+	log := m.log.Named("metrics")
+
+	if m.options.MetricsFile != "" {
+		registry := newMetricsRegistry(m.report, prometheus.Labels{"platform": m.platform})
+		if err := prometheus.WriteToTextfile(m.options.MetricsFile, registry); err != nil {
+			return err
+		}
+		log.Debug("Metrics written", "path", m.options.MetricsFile)
+	}
+
+	if m.options.MetricsPushURL != "" {
+		job := m.options.MetricsJob
+		if job == "" {
+			job = defaultMetricsJob
+		}
+		// The Pushgateway adds grouping labels to the metrics itself
+		registry := newMetricsRegistry(m.report, nil)
+		pusher := push.New(m.options.MetricsPushURL, job).Gatherer(registry).Grouping("platform", m.platform)
+		if hostname, err := os.Hostname(); err == nil {
+			pusher = pusher.Grouping("instance", hostname)
+		}
+		// Push even if the run itself was cancelled, but don't wait forever
+		pushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		if err := pusher.PushContext(pushCtx); err != nil {
+			return err
+		}
+		log.Debug("Metrics pushed", "url", m.options.MetricsPushURL, "job", job)
+	}
+	return nil
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func msToSeconds(ms int64) float64 {
+	return float64(ms) / 1000
+}
@@ -0,0 +1,89 @@
+package npmi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func testReport() *Report {
+	report := newReport()
+	report.InstalledFromCache = true
+	report.Source = "minio"
+	report.Caches = []*CacheReport{
+		{Name: "local", Hit: false},
+		{Name: "minio", Hit: true, BytesDownloaded: 1234},
+	}
+	report.PhaseDurationsMs["extract"] = 1500
+	report.finish(nil)
+	return report
+}
+
+func TestExportMetricsToTextfile(t *testing.T) {
+	metricsFile := filepath.Join(t.TempDir(), "npmi.prom")
+	m := &main{
+		options:  &Options{MetricsFile: metricsFile},
+		platform: "v20.10.0-linux-x64-dev",
+		report:   testReport(),
+		log:      hclog.NewNullLogger(),
+	}
+
+	if err := m.exportMetrics(context.Background()); err != nil {
+		t.Fatalf("exportMetrics() error = %v", err)
+	}
+
+	data, err := os.ReadFile(metricsFile)
+	if err != nil {
+		t.Fatalf("metrics file not written: %v", err)
+	}
+	for _, want := range []string{
+		`npmi_run_success{platform="v20.10.0-linux-x64-dev"} 1`,
+		`npmi_cache_hit{cache="local",platform="v20.10.0-linux-x64-dev"} 0`,
+		`npmi_cache_hit{cache="minio",platform="v20.10.0-linux-x64-dev"} 1`,
+		`npmi_cache_downloaded_bytes{cache="minio",platform="v20.10.0-linux-x64-dev"} 1234`,
+		`npmi_phase_duration_seconds{phase="extract",platform="v20.10.0-linux-x64-dev"} 1.5`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("metrics file does not contain %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestExportMetricsToPushgateway(t *testing.T) {
+	var gotMethod, gotPath, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotMethod, gotPath, gotBody = r.Method, r.URL.Path, string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	m := &main{
+		options:  &Options{MetricsPushURL: server.URL},
+		platform: "v20.10.0-linux-x64-dev",
+		report:   testReport(),
+		log:      hclog.NewNullLogger(),
+	}
+
+	if err := m.exportMetrics(context.Background()); err != nil {
+		t.Fatalf("exportMetrics() error = %v", err)
+	}
+
+	if gotMethod != http.MethodPut {
+		t.Errorf("method = %s, want %s", gotMethod, http.MethodPut)
+	}
+	// The order of the grouping labels following the job is not defined
+	if !strings.HasPrefix(gotPath, "/metrics/job/npmi-go/") || !strings.Contains(gotPath, "/platform/v20.10.0-linux-x64-dev") {
+		t.Errorf("path = %s, want grouping by job and platform", gotPath)
+	}
+	if gotBody == "" {
+		t.Errorf("no metrics pushed")
+	}
+}
@@ -1,6 +1,8 @@
 package npmi
 
 import (
+	"context"
+
 	"github.com/hashicorp/go-hclog"
 	"github.com/hermo/npmi-go/pkg/cmd"
 )
@@ -9,26 +11,82 @@ type NpmInstaller struct {
 	npmBinary      string
 	runner         cmd.Runner
 	log            hclog.Logger
+	installArgs    []string
+	installCommand string
+	modeArgs       []string
 }
 
 func NewNpmInstaller(config *Config, log hclog.Logger) *NpmInstaller {
 	return &NpmInstaller{
-		npmBinary:      config.npmBinary,
-		runner:         config.runner,
-		log:            log,
+		npmBinary: config.npmBinary,
+		runner:    config.commandRunner(),
+		log:       log,
 	}
 }
 
+// SetRunner sets the runner used for running npm and shell commands
+func (i *NpmInstaller) SetRunner(runner cmd.Runner) {
+	i.runner = runner
+}
+
+// SetInstallArgs sets extra arguments passed to npm ci, e.g. --ignore-scripts
+func (i *NpmInstaller) SetInstallArgs(args []string) {
+	i.installArgs = args
+}
+
+// SetMode passes --omit=dev or --include=dev to npm when the mode is given explicitly
+func (i *NpmInstaller) SetMode(mode string) {
+	switch mode {
+	case ModeProduction:
+		i.modeArgs = []string{"--omit=dev"}
+	case ModeDevelopment:
+		i.modeArgs = []string{"--include=dev"}
+	default:
+		i.modeArgs = nil
+	}
+}
+
+// SetInstallCommand replaces npm ci with a custom shell command
+func (i *NpmInstaller) SetInstallCommand(commandLine string) {
+	i.installCommand = commandLine
+}
+
 // Run installs packages from NPM
-func (i *NpmInstaller) Run() (stdout string, stderr string, err error) {
+func (i *NpmInstaller) Run(ctx context.Context) (stdout string, stderr string, err error) {
+	if i.installCommand != "" {
+		i.log.Trace("Running shell", "commandLine", i.installCommand)
+		return i.runner.RunShellCommand(ctx, i.installCommand)
+	}
+
 	var args = []string{"ci", "--loglevel", "error", "--progress", "false"}
+	args = append(args, i.modeArgs...)
+	args = append(args, i.installArgs...)
+
+	i.log.Trace("Running", "npmBinary", i.npmBinary, "args", args)
+	return i.runner.RunCommand(ctx, i.npmBinary, args...)
+}
+
+// Version returns the version of npm
+func (i *NpmInstaller) Version(ctx context.Context) (version string, stderr string, err error) {
+	return i.runner.RunCommand(ctx, i.npmBinary, "--version")
+}
+
+// Prune removes dev deps from installed packages
+func (i *NpmInstaller) Prune(ctx context.Context) (stdout string, stderr string, err error) {
+	var args = []string{"prune", "--omit=dev", "--loglevel", "error", "--progress", "false"}
 
 	i.log.Trace("Running", "npmBinary", i.npmBinary, "args", args)
-	return i.runner.RunCommand(i.npmBinary, args...)
+	return i.runner.RunCommand(ctx, i.npmBinary, args...)
 }
 
 // RunPrecacheCommand runs a given command before inserting freshly installed NPM deps into cache
-func (i *NpmInstaller) RunPrecacheCommand(commandLine string) (stdout string, stderr string, err error) {
+func (i *NpmInstaller) RunPrecacheCommand(ctx context.Context, commandLine string, opts ...cmd.RunOption) (stdout string, stderr string, err error) {
+	i.log.Trace("Running shell", "commandLine", commandLine)
+	return i.runner.RunShellCommand(ctx, commandLine, opts...)
+}
+
+// RunPostRestoreCommand runs a given command after installing NPM deps from cache
+func (i *NpmInstaller) RunPostRestoreCommand(ctx context.Context, commandLine string) (stdout string, stderr string, err error) {
 	i.log.Trace("Running shell", "commandLine", commandLine)
-	return i.runner.RunShellCommand(commandLine)
+	return i.runner.RunShellCommand(ctx, commandLine)
 }
@@ -1,6 +1,8 @@
 package npmi
 
 import (
+	"context"
+	"slices"
 	"testing"
 
 	"github.com/hashicorp/go-hclog"
@@ -25,8 +27,49 @@ func TestNpmInstaller_Run(t *testing.T) {
 	})
 	sut := NewNpmInstaller(nc, log)
 
-	_, _, err := sut.Run()
+	_, _, err := sut.Run(context.Background())
 	if err != nil {
 		t.Errorf("Should not have errored: %v", err)
 	}
 }
+
+func TestNpmInstaller_RunWithInstallArgs(t *testing.T) {
+	runner := &cmd.SpyRunner{}
+	nc := &Config{
+		npmBinary: "npm",
+		runner:    runner,
+	}
+	sut := NewNpmInstaller(nc, hclog.NewNullLogger())
+	sut.SetInstallArgs([]string{"--ignore-scripts", "--omit=dev"})
+
+	if _, _, err := sut.Run(context.Background()); err != nil {
+		t.Fatalf("Should not have errored: %v", err)
+	}
+
+	want := []string{"ci", "--loglevel", "error", "--progress", "false", "--ignore-scripts", "--omit=dev"}
+	if len(runner.RunCommandCalls) != 1 || !slices.Equal(runner.RunCommandCalls[0].Args, want) {
+		t.Errorf("Was expecting npm to be run with %v, got %v", want, runner.RunCommandCalls)
+	}
+}
+
+func TestNpmInstaller_RunWithInstallCommand(t *testing.T) {
+	runner := &cmd.SpyRunner{}
+	nc := &Config{
+		npmBinary: "npm",
+		runner:    runner,
+	}
+	sut := NewNpmInstaller(nc, hclog.NewNullLogger())
+	sut.SetInstallArgs([]string{"--ignore-scripts"})
+	sut.SetInstallCommand("pnpm install --frozen-lockfile")
+
+	if _, _, err := sut.Run(context.Background()); err != nil {
+		t.Fatalf("Should not have errored: %v", err)
+	}
+
+	if len(runner.RunCommandCalls) != 0 {
+		t.Errorf("Was not expecting npm to be run, got %v", runner.RunCommandCalls)
+	}
+	if len(runner.RunShellCommandCalls) != 1 || runner.RunShellCommandCalls[0].CommandLine != "pnpm install --frozen-lockfile" {
+		t.Errorf("Was expecting the install command to be run, got %v", runner.RunShellCommandCalls)
+	}
+}
@@ -1,14 +1,21 @@
 package npmi
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/hermo/npmi-go/pkg/archive"
+	"github.com/hermo/npmi-go/pkg/cache"
 	"github.com/hermo/npmi-go/pkg/cmd"
 	"github.com/hermo/npmi-go/pkg/files"
 )
@@ -51,7 +58,7 @@ func TestNpmiCanBeCreated(t *testing.T) {
 		Stderr: "",
 		Error:  nil,
 	})
-	config, err := builder.Build()
+	config, err := builder.Build(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -101,7 +108,7 @@ func TestNpmiCanBeRun(t *testing.T) {
 		Error:  nil,
 	}
 	builder.WithRunner(runner)
-	config, err := builder.Build()
+	config, err := builder.Build(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -116,7 +123,7 @@ func TestNpmiCanBeRun(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = m.Run()
+	err = m.Run(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -124,9 +131,10 @@ func TestNpmiCanBeRun(t *testing.T) {
 	fmt.Printf("RunCommand calls: %v\n", runner.RunCommandCalls)
 	fmt.Printf("RunShellCommandCalls: %v\n", runner.RunShellCommandCalls)
 
+	// node for the platform, npm ci and npm --version for the archive manifest
 	numRunCommandCalls := len(runner.RunCommandCalls)
-	if numRunCommandCalls != 2 {
-		t.Errorf("Expected %d RunCommand calls, got %d", 2, numRunCommandCalls)
+	if numRunCommandCalls != 3 {
+		t.Errorf("Expected %d RunCommand calls, got %d", 3, numRunCommandCalls)
 	}
 
 	numRunShellCommandCalls := len(runner.RunShellCommandCalls)
@@ -135,6 +143,298 @@ func TestNpmiCanBeRun(t *testing.T) {
 	}
 }
 
+func TestNpmiRunWritesReport(t *testing.T) {
+	_, filename, _, _ := runtime.Caller(0)
+	testDataDir := filepath.Join(filepath.Dir(filename), "../../testdata")
+	if err := os.Chdir(testDataDir); err != nil {
+		t.Fatalf("could not chdir to testdata: %v", err)
+	}
+
+	testDataCacheDir := filepath.Join(testDataDir, "cache")
+	if !files.DirectoryExists("node_modules") {
+		if err := os.Mkdir("node_modules", 0700); err != nil {
+			t.Fatalf("node_modules not present and mkdir failed: %v", err)
+		}
+	}
+
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	options := &Options{
+		LocalCache: &LocalCacheOptions{
+			Dir: testDataCacheDir,
+		},
+		UseLocalCache: true,
+		ReportFile:    reportFile,
+	}
+	builder := NewConfigBuilder()
+	builder.WithRunner(&cmd.SpyRunner{
+		Stdout: "temp-v11.16.3-darwin-x64",
+	})
+	config, err := builder.Build(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewWithConfig(options, config, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Run(context.Background())
+	defer cleanCacheDir(t, testDataCacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatalf("report not written: %v", err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("report is not valid JSON: %v", err)
+	}
+
+	if !report.Success || report.InstalledFromCache {
+		t.Errorf("success=%v installedFromCache=%v, want true, false", report.Success, report.InstalledFromCache)
+	}
+	if report.CacheKey == "" || report.KeyComponents.Platform != "temp-v11.16.3-darwin-x64-dev" {
+		t.Errorf("unexpected key in report: %q %+v", report.CacheKey, report.KeyComponents)
+	}
+	if len(report.Caches) != 1 || report.Caches[0].Name != "local" || report.Caches[0].Hit {
+		t.Fatalf("unexpected caches in report: %+v", report.Caches)
+	}
+	if report.ArchiveSize == 0 || report.Caches[0].BytesUploaded != report.ArchiveSize {
+		t.Errorf("archiveSize=%d bytesUploaded=%d, want equal and non-zero", report.ArchiveSize, report.Caches[0].BytesUploaded)
+	}
+	for _, phase := range []string{"lookup", "install", "archive", "upload"} {
+		if _, ok := report.PhaseDurationsMs[phase]; !ok {
+			t.Errorf("phase %s missing from report", phase)
+		}
+	}
+
+	cached, err := os.Open(filepath.Join(testDataCacheDir, report.CacheKey))
+	if err != nil {
+		t.Fatalf("archive not cached: %v", err)
+	}
+	defer cached.Close()
+	manifest, err := archive.ReadManifest(cached)
+	if err != nil || manifest == nil {
+		t.Fatalf("archive has no manifest: %v", err)
+	}
+	if manifest.CacheKey != report.CacheKey || manifest.NpmiVersion != Version || manifest.NodeVersion != "temp" {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+}
+
+func TestNpmiDerivesProductionTreeFromDevelopmentTree(t *testing.T) {
+	_, filename, _, _ := runtime.Caller(0)
+	testDataDir := filepath.Join(filepath.Dir(filename), "../../testdata")
+	if err := os.Chdir(testDataDir); err != nil {
+		t.Fatalf("could not chdir to testdata: %v", err)
+	}
+
+	testDataCacheDir := filepath.Join(testDataDir, "cache")
+	if !files.DirectoryExists("node_modules") {
+		if err := os.Mkdir("node_modules", 0700); err != nil {
+			t.Fatalf("node_modules not present and mkdir failed: %v", err)
+		}
+	}
+	defer cleanCacheDir(t, testDataCacheDir)
+
+	newMain := func(productionMode bool, runner *cmd.SpyRunner) *main {
+		options := &Options{
+			LocalCache: &LocalCacheOptions{
+				Dir: testDataCacheDir,
+			},
+			UseLocalCache: true,
+			DeriveProd:    true,
+		}
+		builder := NewConfigBuilder()
+		builder.WithRunner(runner)
+		builder.WithProductionModeDeterminatorFunc(func() bool { return productionMode })
+		config, err := builder.Build(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := NewWithConfig(options, config, hclog.NewNullLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	// Populate the cache with the development tree
+	dev := newMain(false, &cmd.SpyRunner{Stdout: "temp-v11.16.3-darwin-x64"})
+	if err := dev.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := &cmd.SpyRunner{Stdout: "temp-v11.16.3-darwin-x64"}
+	prod := newMain(true, runner)
+	if err := prod.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(runner.RunCommandCalls) < 2 || runner.RunCommandCalls[1].Args[0] != "prune" {
+		t.Fatalf("Was expecting node and npm prune to be run, got %v", runner.RunCommandCalls)
+	}
+	for _, call := range runner.RunCommandCalls {
+		if call.Args[0] == "ci" {
+			t.Errorf("Was not expecting npm ci to be run, got %v", runner.RunCommandCalls)
+		}
+	}
+	report := prod.Report()
+	if report.DerivedFrom != dev.Report().CacheKey {
+		t.Errorf("derivedFrom = %q, want %q", report.DerivedFrom, dev.Report().CacheKey)
+	}
+	if report.KeyComponents.Platform != "temp-v11.16.3-darwin-x64-prod" || report.Caches[0].BytesUploaded == 0 {
+		t.Errorf("Was expecting the production tree to be cached, got %+v", report)
+	}
+	// The production key missed the cache
+	if report.InstalledFromCache || report.Source != "" || report.Caches[0].Hit || !report.Caches[0].DerivedHit {
+		t.Errorf("Was expecting a derived tree not to be reported as a cache hit, got %+v", report)
+	}
+}
+
+func TestNpmiRunsPostRestoreCommandAfterCacheHit(t *testing.T) {
+	_, filename, _, _ := runtime.Caller(0)
+	testDataDir := filepath.Join(filepath.Dir(filename), "../../testdata")
+	if err := os.Chdir(testDataDir); err != nil {
+		t.Fatalf("could not chdir to testdata: %v", err)
+	}
+
+	testDataCacheDir := filepath.Join(testDataDir, "cache")
+	if !files.DirectoryExists("node_modules") {
+		if err := os.Mkdir("node_modules", 0700); err != nil {
+			t.Fatalf("node_modules not present and mkdir failed: %v", err)
+		}
+	}
+	defer cleanCacheDir(t, testDataCacheDir)
+
+	run := func() *cmd.SpyRunner {
+		options := &Options{
+			LocalCache: &LocalCacheOptions{
+				Dir: testDataCacheDir,
+			},
+			UseLocalCache:      true,
+			PostRestoreCommand: "npx patch-package",
+		}
+		runner := &cmd.SpyRunner{Stdout: "temp-v11.16.3-darwin-x64"}
+		builder := NewConfigBuilder()
+		builder.WithRunner(runner)
+		config, err := builder.Build(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := NewWithConfig(options, config, hclog.NewNullLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return runner
+	}
+
+	if runner := run(); len(runner.RunShellCommandCalls) != 0 {
+		t.Errorf("Was not expecting the post-restore command to be run after npm install, got %v", runner.RunShellCommandCalls)
+	}
+
+	runner := run()
+	if len(runner.RunShellCommandCalls) != 1 || runner.RunShellCommandCalls[0].CommandLine != "npx patch-package" {
+		t.Errorf("Was expecting the post-restore command to be run after a cache hit, got %v", runner.RunShellCommandCalls)
+	}
+}
+
+// slowCache fetches entries lazily and slowly, like a cache downloading them while they are read
+type slowCache struct {
+	cache.Cacher
+	delay time.Duration
+}
+
+func (c *slowCache) Get(ctx context.Context, key string) (io.Reader, error) {
+	return &slowReader{func() (io.Reader, error) { return c.Cacher.Get(ctx, key) }, nil, c.delay}, nil
+}
+
+type slowReader struct {
+	get   func() (io.Reader, error)
+	r     io.Reader
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.r == nil {
+		time.Sleep(r.delay)
+		reader, err := r.get()
+		if err != nil {
+			return 0, err
+		}
+		r.r = reader
+	}
+	return r.r.Read(p)
+}
+
+func (r *slowReader) Close() error {
+	closeArchive(r.r)
+	return nil
+}
+
+func TestNpmiReportsLazyFetchDuration(t *testing.T) {
+	_, filename, _, _ := runtime.Caller(0)
+	testDataDir := filepath.Join(filepath.Dir(filename), "../../testdata")
+	if err := os.Chdir(testDataDir); err != nil {
+		t.Fatalf("could not chdir to testdata: %v", err)
+	}
+	if !files.DirectoryExists("node_modules") {
+		if err := os.Mkdir("node_modules", 0700); err != nil {
+			t.Fatalf("node_modules not present and mkdir failed: %v", err)
+		}
+	}
+
+	options := &Options{
+		LocalCache: &LocalCacheOptions{
+			Dir: t.TempDir(),
+		},
+		UseLocalCache: true,
+	}
+	builder := NewConfigBuilder()
+	builder.WithRunner(&cmd.SpyRunner{Stdout: "temp-v11.16.3-darwin-x64"})
+	config, err := builder.Build(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Populate the cache
+	m, err := NewWithConfig(options, config, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	const delay = 200 * time.Millisecond
+	caches := []cache.Cacher{&slowCache{m.caches[0], delay}}
+	m = newMain(options, config, caches, hclog.NewNullLogger())
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	report := m.Report()
+	if !report.InstalledFromCache {
+		t.Fatalf("Was expecting an installation from the cache, got %+v", report)
+	}
+	if fetch := report.PhaseDurationsMs["fetch"]; fetch < delay.Milliseconds() {
+		t.Errorf("fetch took %dms, want at least %dms spent reading the archive", fetch, delay.Milliseconds())
+	}
+}
+
+func TestNpmiRejectsInvalidMode(t *testing.T) {
+	options := &Options{Mode: "production"}
+	if _, err := NewWithConfig(options, &Config{}, hclog.NewNullLogger()); err == nil {
+		t.Error("Was expecting an invalid mode to be rejected")
+	}
+}
+
 func cleanCacheDir(t *testing.T, cacheDir string) {
 	fp := path.Join(cacheDir, "temp-*")
 	matches, err := filepath.Glob(fp)
@@ -191,14 +491,14 @@ func TestNpmBehaviorThroughWrapper(t *testing.T) {
 			// Build config with real binaries from PATH
 			builder := NewConfigBuilder()
 			builder.WithNodeAndNpmFromPath()
-			config, err := builder.Build()
+			config, err := builder.Build(context.Background())
 			if err != nil {
 				t.Fatalf("Build failed: %v", err)
 			}
 
 			// Create and run installer
 			installer := NewNpmInstaller(config, hclog.NewNullLogger())
-			_, _, err = installer.Run()
+			_, _, err = installer.Run(context.Background())
 			if err != nil {
 				t.Fatalf("Run failed: %v", err)
 			}
@@ -217,3 +517,49 @@ func checkDependency(t *testing.T, pkg string, want bool) {
 		t.Errorf("Dependency %s exists = %v, want %v", pkg, exists, want)
 	}
 }
+
+func TestMinioRetryPolicy(t *testing.T) {
+	options := DefaultOptions().MinioCache
+	if policy := minioRetryPolicy(options); policy.OperationTimeout != 5*time.Minute {
+		t.Errorf("Default OperationTimeout = %s, want 5m", policy.OperationTimeout)
+	}
+
+	options.Timeout = 0
+	if policy := minioRetryPolicy(options); policy.OperationTimeout != 0 {
+		t.Errorf("OperationTimeout = %s, want disabled", policy.OperationTimeout)
+	}
+}
+
+func TestCountingReaderSeek(t *testing.T) {
+	var count int64
+	reader := &countingReader{strings.NewReader("0123456789"), &count}
+	if _, err := io.CopyN(io.Discard, reader, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	// A retried upload rewinds the reader and reads everything again
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek() error = %v", err)
+	}
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		t.Fatal(err)
+	}
+	if count != 10 {
+		t.Errorf("count = %d, want 10", count)
+	}
+
+	if _, err := (&countingReader{io.MultiReader(), &count}).Seek(0, io.SeekStart); err == nil {
+		t.Errorf("Was expecting an error seeking a reader which is not seekable")
+	}
+}
+
+func TestArchiveFilenameOfLongestKey(t *testing.T) {
+	key := "a"
+	for cache.ValidateKey(key+"a") == nil {
+		key += "a"
+	}
+	path := filepath.Join(t.TempDir(), createArchiveFilename(key))
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Errorf("Could not create archive of a key of %d characters: %v", len(key), err)
+	}
+}
@@ -0,0 +1,208 @@
+package npmi
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// ProjectAuto derives the project identifier from the name in package.json or the git remote
+const ProjectAuto = "auto"
+
+const defaultPackageFile = "package.json"
+
+// resolveProject returns options with an automatic project identifier replaced by the
+// identifier of the project in dir, or the current directory if it is empty. If it
+// can't be determined, no project identifier is used.
+func resolveProject(options *Options, dir string, log hclog.Logger) (*Options, error) {
+	if options.Project != ProjectAuto {
+		return options, nil
+	}
+	log = log.Named("project")
+	log.Trace("start")
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	copied := *options
+	copied.Project = ""
+
+	project, err := projectFromPackageFile(filepath.Join(dir, defaultPackageFile))
+	if err != nil {
+		log.Debug("Could not determine project from package.json", "error", err)
+	}
+	if project == "" {
+		if project, err = projectFromGitRemote(dir); err != nil {
+			log.Debug("Could not determine project from git remote", "error", err)
+		}
+	}
+	if project == "" {
+		log.Warn("Could not determine project, not using a project identifier")
+		return &copied, nil
+	}
+
+	copied.Project = project
+	log.Debug("Project identified", "project", project)
+	log.Trace("complete")
+	return &copied, nil
+}
+
+// cacheNamespace returns the namespace the keys are stored under, consisting of the namespace and the project
+func cacheNamespace(options *Options) string {
+	switch {
+	case options.Namespace == "":
+		return options.Project
+	case options.Project == "":
+		return options.Namespace
+	default:
+		return options.Namespace + "/" + options.Project
+	}
+}
+
+// projectFromPackageFile returns the project identifier for the package name in package.json.
+// A scoped name such as @scope/name results in scope/name.
+func projectFromPackageFile(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	var pkg struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return "", fmt.Errorf("invalid %s: %v", filename, err)
+	}
+	return projectIdentifier(strings.TrimPrefix(pkg.Name, "@")), nil
+}
+
+// projectFromGitRemote returns the project identifier for the URL of the origin remote of the
+// git repository containing dir, e.g. hermo/npmi-go for git@github.com:hermo/npmi-go.git
+func projectFromGitRemote(dir string) (string, error) {
+	configFile, err := findGitConfig(dir)
+	if err != nil {
+		return "", err
+	}
+	remoteURL, err := readGitRemoteURL(configFile, "origin")
+	if err != nil {
+		return "", err
+	}
+
+	path := remoteURL
+	if _, rest, found := strings.Cut(path, "://"); found {
+		// scheme://[user@]host[:port]/path
+		_, path, _ = strings.Cut(rest, "/")
+	} else if _, rest, found := strings.Cut(path, ":"); found {
+		// [user@]host:path
+		path = rest
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	return projectIdentifier(path), nil
+}
+
+// findGitConfig returns the config file of the git repository containing dir
+func findGitConfig(dir string) (string, error) {
+	for {
+		gitPath := filepath.Join(dir, ".git")
+		fi, err := os.Stat(gitPath)
+		if err == nil {
+			if fi.IsDir() {
+				return filepath.Join(gitPath, "config"), nil
+			}
+			return worktreeGitConfig(gitPath)
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("not in a git repository")
+		}
+		dir = parent
+	}
+}
+
+// worktreeGitConfig returns the config file of a worktree or submodule, whose .git is a file
+// pointing at the actual git directory
+func worktreeGitConfig(gitFile string) (string, error) {
+	data, err := os.ReadFile(gitFile)
+	if err != nil {
+		return "", err
+	}
+	gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+	if !ok {
+		return "", fmt.Errorf("invalid %s", gitFile)
+	}
+	gitDir = strings.TrimSpace(gitDir)
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(gitFile), gitDir)
+	}
+	// Worktrees share the config of the main repository
+	if commonDir, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		common := strings.TrimSpace(string(commonDir))
+		if !filepath.IsAbs(common) {
+			common = filepath.Join(gitDir, common)
+		}
+		gitDir = common
+	}
+	return filepath.Join(gitDir, "config"), nil
+}
+
+// readGitRemoteURL reads the URL of a remote from a git config file
+func readGitRemoteURL(configFile string, remote string) (string, error) {
+	f, err := os.Open(configFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	section := fmt.Sprintf(`[remote "%s"]`, remote)
+	inSection := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inSection = line == section
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		name, value, found := strings.Cut(line, "=")
+		if found && strings.TrimSpace(name) == "url" {
+			return strings.TrimSpace(value), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("remote %s not found", remote)
+}
+
+// projectIdentifier turns a slash-separated name into a valid namespace by replacing
+// unsupported characters with dashes
+func projectIdentifier(name string) string {
+	var segments []string
+	for _, segment := range strings.Split(strings.ToLower(name), "/") {
+		segment = strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+				return r
+			}
+			return '-'
+		}, segment)
+		for strings.Contains(segment, "..") {
+			segment = strings.ReplaceAll(segment, "..", ".")
+		}
+		segment = strings.TrimLeft(segment, ".-_")
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return strings.Join(segments, "/")
+}
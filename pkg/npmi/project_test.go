@@ -0,0 +1,82 @@
+package npmi
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProjectIdentifier(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"myapp", "myapp"},
+		{"scope/My App", "scope/my-app"},
+		{"hermo/npmi-go", "hermo/npmi-go"},
+		{"../..//x", "x"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := projectIdentifier(tt.name); got != tt.want {
+			t.Errorf("projectIdentifier(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProjectFromPackageFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "package.json")
+	if err := os.WriteFile(filename, []byte(`{"name": "@scope/name", "version": "1.0.0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := projectFromPackageFile(filename); err != nil || got != "scope/name" {
+		t.Errorf("projectFromPackageFile() = %q, %v, want scope/name", got, err)
+	}
+}
+
+func TestProjectFromGitRemote(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"git@github.com:hermo/npmi-go.git", "hermo/npmi-go"},
+		{"https://github.com/hermo/npmi-go.git", "hermo/npmi-go"},
+		{"ssh://git@git.example.com:2222/team/app/", "team/app"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if err := os.Mkdir(filepath.Join(dir, ".git"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		config := "[core]\n\tbare = false\n[remote \"upstream\"]\n\turl = git@example.com:other/repo.git\n[remote \"origin\"]\n\turl = " + tt.url + "\n"
+		if err := os.WriteFile(filepath.Join(dir, ".git", "config"), []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		subdir := filepath.Join(dir, "packages", "web")
+		if err := os.MkdirAll(subdir, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := projectFromGitRemote(subdir); err != nil || got != tt.want {
+			t.Errorf("projectFromGitRemote() for %s = %q, %v, want %q", tt.url, got, err, tt.want)
+		}
+	}
+}
+
+func TestCacheNamespace(t *testing.T) {
+	tests := []struct {
+		namespace string
+		project   string
+		want      string
+	}{
+		{"", "", ""},
+		{"team-a", "", "team-a"},
+		{"", "myapp", "myapp"},
+		{"team-a", "scope/myapp", "team-a/scope/myapp"},
+	}
+	for _, tt := range tests {
+		if got := cacheNamespace(&Options{Namespace: tt.namespace, Project: tt.project}); got != tt.want {
+			t.Errorf("cacheNamespace(%q, %q) = %q, want %q", tt.namespace, tt.project, got, tt.want)
+		}
+	}
+}
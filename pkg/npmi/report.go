@@ -0,0 +1,174 @@
+package npmi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Report describes the outcome of a run in machine-readable form
+type Report struct {
+	Version            string           `json:"version"`
+	StartedAt          time.Time        `json:"startedAt"`
+	DurationMs         int64            `json:"durationMs"`
+	Success            bool             `json:"success"`
+	Error              string           `json:"error,omitempty"`
+	Project            string           `json:"project,omitempty"`
+	CacheKey           string           `json:"cacheKey"`
+	KeyComponents      KeyComponents    `json:"keyComponents"`
+	InstalledFromCache bool             `json:"installedFromCache"`
+	Source             string           `json:"source,omitempty"`
+	DerivedFrom        string           `json:"derivedFrom,omitempty"`
+	Caches             []*CacheReport   `json:"caches"`
+	ArchiveSize        int64            `json:"archiveSize,omitempty"`
+	FilesExtracted     int              `json:"filesExtracted"`
+	FilesRemoved       int              `json:"filesRemoved"`
+	PhaseDurationsMs   map[string]int64 `json:"phaseDurationsMs"`
+
+	mu sync.Mutex
+}
+
+// KeyComponents lists the inputs a cache key was created from
+type KeyComponents struct {
+	Platform        string     `json:"platform"`
+	LockFileHash    string     `json:"lockFileHash"`
+	PrecacheCommand string     `json:"precacheCommand,omitempty"`
+	Inputs          []KeyInput `json:"inputs,omitempty"`
+}
+
+// CacheReport describes how a single cache was used during a run
+type CacheReport struct {
+	Name string `json:"name"`
+	Hit  bool   `json:"hit"`
+	// DerivedHit is true if the development tree a production tree was derived from was found
+	DerivedHit      bool  `json:"derivedHit,omitempty"`
+	BytesDownloaded int64 `json:"bytesDownloaded"`
+	BytesUploaded   int64 `json:"bytesUploaded"`
+}
+
+func newReport() *Report {
+	return &Report{
+		Version:          Version,
+		StartedAt:        time.Now(),
+		PhaseDurationsMs: map[string]int64{},
+	}
+}
+
+// cache returns the report of the named cache, creating it if necessary
+func (r *Report) cache(name string) *CacheReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.Caches {
+		if c.Name == name {
+			return c
+		}
+	}
+	c := &CacheReport{Name: name}
+	r.Caches = append(r.Caches, c)
+	return c
+}
+
+// startPhase starts timing a phase. The returned func adds the time elapsed to the
+// phase's duration; phases run several times, e.g. once per cache, are summed up.
+func (r *Report) startPhase(name string) func() {
+	start := time.Now()
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.PhaseDurationsMs[name] += time.Since(start).Milliseconds()
+	}
+}
+
+// finish records the final state of the run
+func (r *Report) finish(err error) {
+	r.DurationMs = time.Since(r.StartedAt).Milliseconds()
+	r.Success = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// WriteFile writes the report as JSON to a file
+func (r *Report) WriteFile(filename string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r     io.Reader
+	count *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.count += int64(n)
+	return n, err
+}
+
+// Seek seeks the underlying reader if it is an io.Seeker, so that uploads can be retried.
+// Bytes read again after rewinding are not counted twice.
+func (c *countingReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := c.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("reader is not seekable")
+	}
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	position, err := seeker.Seek(offset, whence)
+	if err != nil {
+		return position, err
+	}
+	*c.count = max(*c.count+position-current, 0)
+	return position, nil
+}
+
+// Close closes the underlying reader if it needs closing
+func (c *countingReader) Close() error {
+	if closer, ok := c.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// phaseReader ends a phase once the reader it wraps has been read, fails or is closed
+type phaseReader struct {
+	r     io.Reader
+	end   func(err error)
+	ended bool
+}
+
+func (p *phaseReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err == io.EOF {
+		p.finish(nil)
+	} else if err != nil {
+		p.finish(err)
+	}
+	return n, err
+}
+
+// Close closes the underlying reader if it needs closing and ends the phase
+func (p *phaseReader) Close() error {
+	var err error
+	if closer, ok := p.r.(io.Closer); ok {
+		err = closer.Close()
+	}
+	p.finish(nil)
+	return err
+}
+
+func (p *phaseReader) finish(err error) {
+	if !p.ended {
+		p.ended = true
+		p.end(err)
+	}
+}
@@ -0,0 +1,215 @@
+package npmi
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hermo/npmi-go/pkg/cache"
+)
+
+// defaultSyncConcurrency is the number of entries copied at the same time unless configured otherwise
+const defaultSyncConcurrency = 4
+
+// SyncOptions describes which entries are copied between caches and how
+type SyncOptions struct {
+	// Prefix limits the entries to keys starting with it, e.g. a platform
+	Prefix string
+	// MaxAge limits the entries to ones stored at most this long ago. Zero copies all entries.
+	MaxAge time.Duration
+	// Concurrency is the number of entries copied at the same time
+	Concurrency int
+	// DryRun only reports the entries which would be copied
+	DryRun bool
+}
+
+// SyncResult summarizes a sync
+type SyncResult struct {
+	Copied  int
+	Skipped int
+	Failed  int
+	Bytes   int64
+}
+
+// Sync copies the entries missing from the cache configured by target from the cache
+// configured by source. Both options must enable exactly one cache.
+func Sync(ctx context.Context, source *Options, target *Options, syncOptions *SyncOptions, log hclog.Logger) (*SyncResult, error) {
+	log = log.Named("sync")
+	log.Trace("start")
+
+	from, err := initSyncCache(source, log.Named("from"))
+	if err != nil {
+		return nil, fmt.Errorf("source: %v", err)
+	}
+	to, err := initSyncCache(target, log.Named("to"))
+	if err != nil {
+		return nil, fmt.Errorf("target: %v", err)
+	}
+
+	result, err := syncCaches(ctx, from, to, syncOptions, log)
+	if err != nil {
+		return result, err
+	}
+	log.Trace("complete", "copied", result.Copied, "skipped", result.Skipped, "failed", result.Failed)
+	return result, nil
+}
+
+// initSyncCache initializes the single cache enabled in options
+func initSyncCache(options *Options, log hclog.Logger) (cache.Cacher, error) {
+	options, err := resolveProject(options, "", log)
+	if err != nil {
+		return nil, err
+	}
+	caches, err := initCaches(options, log)
+	if err != nil {
+		return nil, err
+	}
+	if len(caches) != 1 {
+		return nil, fmt.Errorf("exactly one cache must be enabled, got %d", len(caches))
+	}
+	return caches[0], nil
+}
+
+// syncCaches copies the entries selected by syncOptions missing from to from from
+func syncCaches(ctx context.Context, from cache.Cacher, to cache.Cacher, syncOptions *SyncOptions, log hclog.Logger) (*SyncResult, error) {
+	lister, ok := from.(cache.Lister)
+	if !ok {
+		return nil, fmt.Errorf("%s cache can't list its entries", from)
+	}
+	entries, err := lister.List(ctx, syncOptions.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("could not list %s cache: %v", from, err)
+	}
+
+	if syncOptions.MaxAge > 0 {
+		oldest := time.Now().Add(-syncOptions.MaxAge)
+		entries = slices.DeleteFunc(entries, func(entry cache.Entry) bool {
+			return entry.ModTime.Before(oldest)
+		})
+	}
+	log.Debug("Entries to sync", "from", fmt.Sprint(from), "to", fmt.Sprint(to), "entries", len(entries))
+
+	concurrency := syncOptions.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultSyncConcurrency
+	}
+
+	result := &SyncResult{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan cache.Entry)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range queue {
+				copied, err := syncEntry(ctx, from, to, entry, syncOptions.DryRun, log)
+				mu.Lock()
+				switch {
+				case err != nil:
+					log.Error("Sync failed", "cacheKey", entry.Key, "error", err)
+					result.Failed++
+				case copied:
+					result.Copied++
+					result.Bytes += entry.Size
+				default:
+					result.Skipped++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- entry
+	}
+	close(queue)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if result.Failed > 0 {
+		return result, fmt.Errorf("%d of %d entries failed to sync", result.Failed, len(entries))
+	}
+	return result, nil
+}
+
+// syncEntry copies an entry unless the target already has it. It returns whether
+// the entry was (or in a dry run, would have been) copied.
+func syncEntry(ctx context.Context, from cache.Cacher, to cache.Cacher, entry cache.Entry, dryRun bool, log hclog.Logger) (bool, error) {
+	found, err := to.Has(ctx, entry.Key)
+	if err != nil {
+		return false, err
+	}
+	if found {
+		log.Debug("Already present, skipping", "cacheKey", entry.Key)
+		return false, nil
+	}
+	if dryRun {
+		log.Info("Would copy", "cacheKey", entry.Key, "size", entry.Size)
+		return true, nil
+	}
+
+	// Keep the metadata describing what created the entry
+	metadata := entry.Metadata
+	if stater, ok := from.(cache.Stater); ok && metadata == nil {
+		stat, err := stater.Stat(ctx, entry.Key)
+		if err != nil {
+			return false, err
+		}
+		if stat != nil {
+			metadata = stat.Metadata
+		}
+	}
+
+	reader, err := from.Get(ctx, entry.Key)
+	if err != nil {
+		return false, err
+	}
+	defer closeArchive(reader)
+	if err := cache.PutWithMetadata(ctx, to, entry.Key, reader, metadata); err != nil {
+		return false, err
+	}
+	log.Info("Copied", "cacheKey", entry.Key, "size", entry.Size)
+	return true, nil
+}
+
+// SyncCacheOptions returns a copy of options enabling only the named cache,
+// which is one of "local", "minio", "http" or "exec"
+func SyncCacheOptions(options *Options, name string) (*Options, error) {
+	copied := *options
+	if options.LocalCache != nil {
+		localCache := *options.LocalCache
+		copied.LocalCache = &localCache
+	}
+	if options.MinioCache != nil {
+		minioCache := *options.MinioCache
+		copied.MinioCache = &minioCache
+	}
+	if options.HTTPCache != nil {
+		httpCache := *options.HTTPCache
+		copied.HTTPCache = &httpCache
+	}
+	if options.ExecCache != nil {
+		execCache := *options.ExecCache
+		copied.ExecCache = &execCache
+	}
+
+	name = strings.ToLower(name)
+	copied.UseLocalCache = name == "local"
+	copied.UseMinioCache = name == "minio"
+	copied.UseHTTPCache = name == "http"
+	copied.UseExecCache = name == "exec"
+	if !copied.UseLocalCache && !copied.UseMinioCache && !copied.UseHTTPCache && !copied.UseExecCache {
+		return nil, fmt.Errorf("unknown cache '%s', must be local, minio, http or exec", name)
+	}
+	return &copied, nil
+}
@@ -0,0 +1,126 @@
+package npmi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestSync(t *testing.T) {
+	sourceDir, targetDir := t.TempDir(), t.TempDir()
+	for name, content := range map[string]string{
+		"v20.10.0-linux-x64-prod-abc":  "new",
+		"v20.10.0-linux-x64-prod-old":  "old",
+		"v20.10.0-linux-x64-prod-def":  "present",
+		"v18.19.0-linux-x64-prod-abc":  "other platform",
+		"v20.10.0-linux-x64-prod.lock": "",
+		".tmp-123":                     "partial",
+	} {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(filepath.Join(sourceDir, "v20.10.0-linux-x64-prod-old"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(targetDir, "v20.10.0-linux-x64-prod-def"), []byte("present"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	source := &Options{UseLocalCache: true, LocalCache: &LocalCacheOptions{Dir: sourceDir}}
+	target := &Options{UseLocalCache: true, LocalCache: &LocalCacheOptions{Dir: targetDir}}
+	syncOptions := &SyncOptions{Prefix: "v20.10.0-", MaxAge: 24 * time.Hour, Concurrency: 2, DryRun: true}
+
+	result, err := Sync(context.Background(), source, target, syncOptions, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if result.Copied != 1 || result.Skipped != 1 || result.Failed != 0 {
+		t.Errorf("Sync() dry run = %+v, want 1 copied and 1 skipped", result)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "v20.10.0-linux-x64-prod-abc")); !os.IsNotExist(err) {
+		t.Errorf("Dry run copied an entry")
+	}
+
+	syncOptions.DryRun = false
+	result, err = Sync(context.Background(), source, target, syncOptions, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if result.Copied != 1 || result.Bytes != 3 {
+		t.Errorf("Sync() = %+v, want 1 copied", result)
+	}
+
+	entries, err := os.ReadDir(targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		// Lock files are left behind by Put
+		if !strings.HasSuffix(entry.Name(), ".lock") {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) != 2 || names[0] != "v20.10.0-linux-x64-prod-abc" || names[1] != "v20.10.0-linux-x64-prod-def" {
+		t.Errorf("target cache contains %v", names)
+	}
+	if data, _ := os.ReadFile(filepath.Join(targetDir, "v20.10.0-linux-x64-prod-abc")); string(data) != "new" {
+		t.Errorf("copied entry contains %q", data)
+	}
+}
+
+func TestSyncSkipsStrayFiles(t *testing.T) {
+	sourceDir, targetDir := t.TempDir(), t.TempDir()
+	for name, content := range map[string]string{
+		"v20.10.0-linux-x64-prod-abc":   "new",
+		"#v20.10.0-linux-x64-prod-abc#": "editor backup",
+		"v20.10.0 notes.txt":            "notes",
+	} {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unreadable := filepath.Join(sourceDir, "v20.10.0-private")
+	// Root can read the directory anyway
+	if err := os.Mkdir(unreadable, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(unreadable, 0755) })
+
+	source := &Options{UseLocalCache: true, LocalCache: &LocalCacheOptions{Dir: sourceDir}}
+	target := &Options{UseLocalCache: true, LocalCache: &LocalCacheOptions{Dir: targetDir}}
+	syncOptions := &SyncOptions{Concurrency: 2}
+
+	result, err := Sync(context.Background(), source, target, syncOptions, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if result.Copied != 1 || result.Failed != 0 {
+		t.Errorf("Sync() = %+v, want 1 copied", result)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "v20.10.0 notes.txt")); !os.IsNotExist(err) {
+		t.Errorf("Sync() copied a file not named like a key")
+	}
+}
+
+func TestSyncCacheOptions(t *testing.T) {
+	options := &Options{UseLocalCache: true, UseMinioCache: true, LocalCache: &LocalCacheOptions{Dir: "/tmp"}, MinioCache: &MinioCacheOptions{}}
+	minio, err := SyncCacheOptions(options, "minio")
+	if err != nil || minio.UseLocalCache || !minio.UseMinioCache {
+		t.Errorf("SyncCacheOptions() = %+v, %v", minio, err)
+	}
+	minio.MinioCache.Bucket = "other"
+	if options.MinioCache.Bucket != "" {
+		t.Errorf("SyncCacheOptions() did not copy cache options")
+	}
+	if _, err := SyncCacheOptions(options, "s3"); err == nil {
+		t.Errorf("Was expecting an unknown cache to be rejected")
+	}
+}
@@ -0,0 +1,100 @@
+package npmi
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/hermo/npmi-go/pkg/npmi"
+
+// InitTracing sets up exporting OpenTelemetry spans via OTLP/HTTP when an endpoint is
+// configured using the standard OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT env variables. Other OTEL_* variables, e.g. for
+// headers and resource attributes, are honored as well.
+//
+// A parent span may be passed in the TRACEPARENT env variable using the W3C Trace
+// Context format, so that the spans of npmi-go appear inside a CI pipeline trace.
+//
+// The returned context carries the parent span, if any. shutdown flushes pending
+// spans and must be called before exiting.
+func InitTracing(ctx context.Context) (tracingCtx context.Context, shutdown func(context.Context) error, err error) {
+	shutdown = func(context.Context) error { return nil }
+	if !isTracingEnabled() {
+		return ctx, shutdown, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return ctx, shutdown, err
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(semconv.ServiceName("npmi-go"), semconv.ServiceVersion(Version)),
+	)
+	if err != nil {
+		return ctx, shutdown, err
+	}
+	// Allow OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES to override the defaults
+	envRes, err := resource.New(ctx, resource.WithFromEnv())
+	if err != nil {
+		return ctx, shutdown, err
+	}
+	if res, err = resource.Merge(res, envRes); err != nil {
+		return ctx, shutdown, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	propagator := propagation.TraceContext{}
+	otel.SetTextMapPropagator(propagator)
+	if traceParent := os.Getenv("TRACEPARENT"); traceParent != "" {
+		ctx = propagator.Extract(ctx, propagation.MapCarrier{
+			"traceparent": traceParent,
+			"tracestate":  os.Getenv("TRACESTATE"),
+		})
+	}
+
+	return ctx, provider.Shutdown, nil
+}
+
+// isTracingEnabled determines whether an OTLP endpoint has been configured for traces
+func isTracingEnabled() bool {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return false
+	}
+	if strings.EqualFold(os.Getenv("OTEL_TRACES_EXPORTER"), "none") {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// startSpan starts a span using the globally configured tracer provider.
+// Without InitTracing, spans are not recorded.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends a span, marking it as failed if err is not nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
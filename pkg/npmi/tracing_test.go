@@ -0,0 +1,102 @@
+package npmi
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace/noop"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeCollector is an in-process OTLP/HTTP trace receiver
+type fakeCollector struct {
+	mu    sync.Mutex
+	spans map[string]string // span name -> hex trace ID
+}
+
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req collectortrace.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				c.spans[span.Name] = hex.EncodeToString(span.TraceId)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestInitTracingExportsSpans(t *testing.T) {
+	collector := &fakeCollector{spans: map[string]string{}}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	parentTraceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL)
+	t.Setenv("TRACEPARENT", "00-"+parentTraceID+"-00f067aa0ba902b7-01")
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	ctx, shutdown, err := InitTracing(context.Background())
+	if err != nil {
+		t.Fatalf("InitTracing() error = %v", err)
+	}
+
+	m := &main{report: newReport()}
+	ctx, span := startSpan(ctx, "npmi-go")
+	_, endPhase := m.startPhase(ctx, "lookup")
+	endPhase(nil)
+	endSpan(span, nil)
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	for _, name := range []string{"npmi-go", "lookup"} {
+		traceID, ok := collector.spans[name]
+		if !ok {
+			t.Errorf("span %q not received by collector, got %v", name, collector.spans)
+			continue
+		}
+		if traceID != parentTraceID {
+			t.Errorf("span %q trace ID = %s, want %s from TRACEPARENT", name, traceID, parentTraceID)
+		}
+	}
+	if _, ok := m.report.PhaseDurationsMs["lookup"]; !ok {
+		t.Errorf("phase lookup missing from report")
+	}
+}
+
+func TestInitTracingDisabledWithoutEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+
+	if isTracingEnabled() {
+		t.Errorf("isTracingEnabled() = true without an endpoint")
+	}
+}
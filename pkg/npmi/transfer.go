@@ -0,0 +1,114 @@
+package npmi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/hermo/npmi-go/pkg/archive"
+	"github.com/hermo/npmi-go/pkg/cache"
+)
+
+// Export copies the archive of a cache key from the first cache having it to a file,
+// e.g. for carrying it to an air-gapped environment. If cacheKey is empty, the key
+// of the current project is used. It returns the exported key.
+func (m *main) Export(ctx context.Context, cacheKey string, filename string) (exportedKey string, err error) {
+	log := m.log.Named("export")
+	log.Trace("start")
+
+	if cacheKey == "" {
+		if cacheKey, err = m.createCacheKey(); err != nil {
+			return "", err
+		}
+	}
+
+	fetched, err := m.fetchArchive(ctx, cacheKey)
+	if err != nil {
+		return "", err
+	}
+	defer closeArchive(fetched)
+
+	// Write to a temporary file first, so that a failed export leaves no partial file behind
+	f, err := os.CreateTemp(filepath.Dir(filename), ".npmi-export-*")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	size, err := io.Copy(f, fetched)
+	if err != nil {
+		return "", fmt.Errorf("can't write %s: %v", filename, err)
+	}
+	if err = f.Close(); err != nil {
+		return "", err
+	}
+	if err = os.Chmod(f.Name(), 0644); err != nil {
+		return "", err
+	}
+	if err = os.Rename(f.Name(), filename); err != nil {
+		return "", err
+	}
+
+	log.Trace("complete", "cacheKey", cacheKey, "path", filename, "size", size)
+	return cacheKey, nil
+}
+
+// Import validates an archive file and stores it in all caches. If cacheKey is empty,
+// the key recorded in the manifest of the archive is used. It returns the imported key.
+func (m *main) Import(ctx context.Context, filename string, cacheKey string) (string, error) {
+	log := m.log.Named("import")
+	log.Trace("start")
+
+	if len(m.caches) == 0 {
+		return "", errors.New("no caches enabled")
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	manifest, err := archive.Validate(ctx, f)
+	if err != nil {
+		return "", fmt.Errorf("invalid archive %s: %v", filename, err)
+	}
+
+	switch {
+	case manifest == nil && cacheKey == "":
+		return "", fmt.Errorf("archive %s has no manifest, the cache key must be given", filename)
+	case cacheKey == "":
+		cacheKey = manifest.CacheKey
+	case manifest != nil && manifest.CacheKey != cacheKey:
+		log.Warn("Importing archive using a cache key other than the one it was created for", "cacheKey", cacheKey, "manifestCacheKey", manifest.CacheKey)
+	}
+	if cacheKey == "" {
+		return "", fmt.Errorf("archive %s has no cache key in its manifest, the cache key must be given", filename)
+	}
+	// The key may come from an untrusted file
+	if err := cache.ValidateKey(cacheKey); err != nil {
+		return "", err
+	}
+
+	metadata := entryMetadata(manifest)
+	for _, c := range m.caches {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		if err := cache.PutWithMetadata(ctx, c, cacheKey, f, metadata); err != nil {
+			return "", fmt.Errorf("%s: %v", c, err)
+		}
+		log.Debug("Archive stored", "cache", fmt.Sprint(c), "cacheKey", cacheKey)
+	}
+
+	log.Trace("complete", "cacheKey", cacheKey)
+	return cacheKey, nil
+}
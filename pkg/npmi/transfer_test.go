@@ -0,0 +1,83 @@
+package npmi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hermo/npmi-go/pkg/archive"
+)
+
+func newTransferTestMain(t *testing.T, cacheDir string) *main {
+	options := &Options{
+		LocalCache: &LocalCacheOptions{
+			Dir: cacheDir,
+		},
+		UseLocalCache: true,
+	}
+	m, err := NewWithConfig(options, &Config{}, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestExportAndImport(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if err := os.MkdirAll("node_modules/a", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("node_modules/a/index.js", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const cacheKey = "v20.10.0-linux-x64-dev-abc"
+	if _, err := archive.Create(context.Background(), "archive.tgz", "node_modules", &archive.Manifest{CacheKey: cacheKey}, &archive.TarOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	source := newTransferTestMain(t, t.TempDir())
+	if _, err := source.Import(context.Background(), "archive.tgz", ""); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	exported := filepath.Join(dir, "exported.tgz")
+	if _, err := source.Export(context.Background(), "missing", exported); err == nil {
+		t.Error("Was expecting exporting a missing key to fail")
+	}
+	key, err := source.Export(context.Background(), cacheKey, exported)
+	if err != nil || key != cacheKey {
+		t.Fatalf("Export() = %s, %v", key, err)
+	}
+
+	target := newTransferTestMain(t, t.TempDir())
+	key, err = target.Import(context.Background(), exported, "")
+	if err != nil || key != cacheKey {
+		t.Fatalf("Import() = %s, %v", key, err)
+	}
+	found, err := target.caches[0].Has(context.Background(), cacheKey)
+	if err != nil || !found {
+		t.Errorf("Was expecting the key to be imported, got %v, %v", found, err)
+	}
+
+	if _, err := target.Import(context.Background(), exported, "../escape"); err == nil {
+		t.Error("Was expecting an invalid key to be rejected")
+	}
+
+	if err := os.WriteFile("invalid.tgz", []byte("not an archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := target.Import(context.Background(), "invalid.tgz", "key"); err == nil {
+		t.Error("Was expecting an invalid archive to be rejected")
+	}
+}
@@ -1,6 +1,13 @@
 package npmi
 
-import "strings"
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hermo/npmi-go/pkg/cache"
+)
 
 type LogLevel int32
 
@@ -40,34 +47,122 @@ func (l LogLevel) String() string {
 	}
 }
 
+// UnmarshalText parses a log level, e.g. from an env variable or a configuration file
+func (l *LogLevel) UnmarshalText(text []byte) error {
+	level := LogLevelFromString(string(text))
+	if level == NoLevel {
+		return fmt.Errorf("invalid loglevel '%s'", text)
+	}
+	*l = level
+	return nil
+}
+
+// Install modes
+const (
+	// ModeAuto determines the mode from NODE_ENV and leaves installing dev deps up to npm
+	ModeAuto = "auto"
+	// ModeProduction installs without dev deps
+	ModeProduction = "prod"
+	// ModeDevelopment installs including dev deps
+	ModeDevelopment = "dev"
+)
+
 // MinioCacheOptions contains configuration for Minio Cache
 type MinioCacheOptions struct {
-	Endpoint        string `env:"NPMI_MINIO_ENDPOINT"`
-	AccessKeyID     string `env:"NPMI_MINIO_ACCESS_KEY_ID"`
-	SecretAccessKey string `env:"NPMI_MINIO_SECRET_ACCESS_KEY"`
-	Bucket          string `env:"NPMI_MINIO_BUCKET"`
-	UseTLS          bool   `env:"NPMI_MINIO_TLS"`
-	InsecureTLS     bool   `env:"NPMI_MINIO_TLS_INSECURE"`
+	Endpoint        string        `env:"NPMI_MINIO_ENDPOINT" yaml:"endpoint"`
+	AccessKeyID     string        `env:"NPMI_MINIO_ACCESS_KEY_ID" yaml:"accessKeyId"`
+	SecretAccessKey string        `env:"NPMI_MINIO_SECRET_ACCESS_KEY" yaml:"secretAccessKey"`
+	Bucket          string        `env:"NPMI_MINIO_BUCKET" yaml:"bucket"`
+	UseTLS          bool          `env:"NPMI_MINIO_TLS" yaml:"tls"`
+	InsecureTLS     bool          `env:"NPMI_MINIO_TLS_INSECURE" yaml:"tlsInsecure"`
+	Timeout         time.Duration `env:"NPMI_MINIO_TIMEOUT" yaml:"timeout"`
+	Deadline        time.Duration `env:"NPMI_MINIO_DEADLINE" yaml:"deadline"`
+	Retries         int           `env:"NPMI_MINIO_RETRIES" yaml:"retries"`
+	RetryBackoff    time.Duration `env:"NPMI_MINIO_RETRY_BACKOFF" yaml:"retryBackoff"`
+	LockTTL         time.Duration `env:"NPMI_MINIO_LOCK_TTL" yaml:"lockTtl"`
+}
+
+// HTTPCacheOptions contains configuration for a cache served by npmi-go serve
+type HTTPCacheOptions struct {
+	URL   string `env:"NPMI_HTTP_URL" yaml:"url"`
+	Token string `env:"NPMI_HTTP_TOKEN" yaml:"token"`
+}
+
+// ExecCacheOptions contains configuration for a cache implemented by a plugin executable
+type ExecCacheOptions struct {
+	Command string   `env:"NPMI_EXEC_COMMAND" yaml:"command"`
+	Args    []string `env:"NPMI_EXEC_ARGS" envSeparator:" " yaml:"args"`
 }
 
 // LocalCacheOptions constains configuration for Local Cache
 type LocalCacheOptions struct {
-	Dir string `env:"NPMI_LOCAL_DIR"`
+	Dir string `env:"NPMI_LOCAL_DIR" yaml:"dir"`
 }
 
 // Options describes the runtime configuration
 type Options struct {
-	Verbose            bool `env:"NPMI_VERBOSE"`
-	Force              bool `env:"NPMI_FORCE"`
-	LocalCache         *LocalCacheOptions
-	LogLevel           LogLevel `env:"NPMI_LOGLEVEL"`
-	MinioCache         *MinioCacheOptions
-	PrecacheCommand    string `env:"NPMI_PRECACHE"`
-	TempDir            string `env:"NPMI_TEMP_DIR"`
-	UseLocalCache      bool   `env:"NPMI_LOCAL"`
-	UseMinioCache      bool   `env:"NPMI_MINIO"`
-	Json               bool   `env:"NPMI_JSON"`
-	TarDoubleDotPaths  bool   `env:"NPMI_TAR_DOUBLE_DOT_PATHS"`
-	TarAbsolutePaths   bool   `env:"NPMI_TAR_ABSOLUTE_PATHS"`
-	TarLinksOutsideCwd bool   `env:"NPMI_TAR_LINKS_OUTSIDE_CWD"`
+	Verbose            bool               `env:"NPMI_VERBOSE" yaml:"verbose"`
+	Force              bool               `env:"NPMI_FORCE" yaml:"force"`
+	LockTimeout        time.Duration      `env:"NPMI_LOCK_TIMEOUT" yaml:"lockTimeout"`
+	LocalCache         *LocalCacheOptions `yaml:"localCache"`
+	LogLevel           LogLevel           `env:"NPMI_LOGLEVEL" yaml:"loglevel"`
+	MinioCache         *MinioCacheOptions `yaml:"minioCache"`
+	HTTPCache          *HTTPCacheOptions  `yaml:"httpCache"`
+	ExecCache          *ExecCacheOptions  `yaml:"execCache"`
+	Mode               string             `env:"NPMI_MODE" yaml:"mode"`
+	DeriveProd         bool               `env:"NPMI_DERIVE_PROD" yaml:"deriveProd"`
+	PrecacheCommand    string             `env:"NPMI_PRECACHE" yaml:"precache"`
+	PrecacheHooks      []Hook             `yaml:"precacheHooks"`
+	PostRestoreCommand string             `env:"NPMI_POSTRESTORE" yaml:"postrestore"`
+	Stream             bool               `env:"NPMI_STREAM" yaml:"stream"`
+	StreamTail         int                `env:"NPMI_STREAM_TAIL" yaml:"streamTail"`
+	InstallArgs        []string           `env:"NPMI_INSTALL_ARGS" envSeparator:" " yaml:"installArgs"`
+	InstallCommand     string             `env:"NPMI_INSTALL_COMMAND" yaml:"installCommand"`
+	KeyFiles           []string           `env:"NPMI_KEY_FILES" yaml:"keyFiles"`
+	KeyEnv             []string           `env:"NPMI_KEY_ENV" yaml:"keyEnv"`
+	PlatformDistro     bool               `env:"NPMI_PLATFORM_DISTRO" yaml:"platformDistro"`
+	Namespace          string             `env:"NPMI_NAMESPACE" yaml:"namespace"`
+	Project            string             `env:"NPMI_PROJECT" yaml:"project"`
+	ReportFile         string             `env:"NPMI_REPORT" yaml:"report"`
+	MetricsFile        string             `env:"NPMI_METRICS_FILE" yaml:"metricsFile"`
+	MetricsPushURL     string             `env:"NPMI_METRICS_PUSH_URL" yaml:"metricsPushUrl"`
+	MetricsJob         string             `env:"NPMI_METRICS_JOB" yaml:"metricsJob"`
+	TempDir            string             `env:"NPMI_TEMP_DIR" yaml:"tempDir"`
+	UseLocalCache      bool               `env:"NPMI_LOCAL" yaml:"local"`
+	UseMinioCache      bool               `env:"NPMI_MINIO" yaml:"minio"`
+	UseHTTPCache       bool               `env:"NPMI_HTTP" yaml:"http"`
+	UseExecCache       bool               `env:"NPMI_EXEC" yaml:"exec"`
+	Json               bool               `env:"NPMI_JSON" yaml:"json"`
+	TarDoubleDotPaths  bool               `env:"NPMI_TAR_DOUBLE_DOT_PATHS" yaml:"tarDoubleDotPaths"`
+	TarAbsolutePaths   bool               `env:"NPMI_TAR_ABSOLUTE_PATHS" yaml:"tarAbsolutePaths"`
+	TarLinksOutsideCwd bool               `env:"NPMI_TAR_LINKS_OUTSIDE_CWD" yaml:"tarLinksOutsideCwd"`
+}
+
+// DefaultOptions returns the options used unless configured otherwise. The local
+// cache in the system temp directory is enabled.
+func DefaultOptions() *Options {
+	retryPolicy := cache.DefaultRetryPolicy()
+	return &Options{
+		LogLevel:           Info,
+		UseLocalCache:      true,
+		TarDoubleDotPaths:  true,
+		TarAbsolutePaths:   true,
+		TarLinksOutsideCwd: true,
+		Mode:               ModeAuto,
+		StreamTail:         50,
+		TempDir:            os.TempDir(),
+		MetricsJob:         "npmi-go",
+		LocalCache: &LocalCacheOptions{
+			Dir: os.TempDir(),
+		},
+		MinioCache: &MinioCacheOptions{
+			UseTLS:       true,
+			Timeout:      retryPolicy.OperationTimeout,
+			Deadline:     retryPolicy.Deadline,
+			Retries:      retryPolicy.MaxAttempts - 1,
+			RetryBackoff: retryPolicy.InitialBackoff,
+		},
+		HTTPCache: &HTTPCacheOptions{},
+		ExecCache: &ExecCacheOptions{},
+	}
 }
@@ -0,0 +1,64 @@
+package npmi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hermo/npmi-go/pkg/archive"
+)
+
+// Verify compares the installed packages against an archive. If archiveFile is empty,
+// the archive cached for the current cache key is used.
+func (m *main) Verify(ctx context.Context, archiveFile string) ([]archive.Difference, error) {
+	log := m.log.Named("verify")
+	log.Trace("start")
+
+	var reader io.Reader
+	if archiveFile != "" {
+		f, err := os.Open(archiveFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		reader = f
+	} else {
+		cacheKey, err := m.createCacheKey()
+		if err != nil {
+			return nil, err
+		}
+		fetched, err := m.fetchArchive(ctx, cacheKey)
+		if err != nil {
+			return nil, err
+		}
+		defer closeArchive(fetched)
+		reader = fetched
+	}
+
+	differences, err := archive.Verify(ctx, reader, m.modulesDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Trace("complete", "differences", len(differences))
+	return differences, nil
+}
+
+// fetchArchive gets the archive of a cache key from the first cache having it
+func (m *main) fetchArchive(ctx context.Context, cacheKey string) (io.Reader, error) {
+	for _, cache := range m.caches {
+		cLog := m.log.Named("cache").Named(fmt.Sprint(cache))
+		found, err := cache.Has(ctx, cacheKey)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			cLog.Debug("cache MISS", "cacheKey", cacheKey)
+			continue
+		}
+		cLog.Debug("cache HIT", "cacheKey", cacheKey)
+		return cache.Get(ctx, cacheKey)
+	}
+	return nil, fmt.Errorf("archive for cache key %s not found in cache", cacheKey)
+}
@@ -0,0 +1,263 @@
+package npmi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hermo/npmi-go/pkg/files"
+)
+
+// WarmResult describes the outcome of warming the cache for one Node.js binary
+type WarmResult struct {
+	Node       string
+	NodeBinary string
+	CacheKey   string
+	// Cached is true if the entry was already present in a cache
+	Cached bool
+	Err    error
+}
+
+// Warm makes sure the caches contain an entry for the project in the current directory
+// for each of the given Node.js binaries. A node may be given as a path to a node binary
+// or as a version installed using nvm, volta or fnm, e.g. 20 or v20.10.0.
+// Missing entries are installed and stored one at a time in a temporary copy of the project.
+// The current directory and environment of the process are not changed.
+func Warm(ctx context.Context, options *Options, nodes []string, log hclog.Logger) ([]*WarmResult, error) {
+	log = log.Named("warm")
+	log.Trace("start", "nodes", nodes)
+
+	projectDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	// The project is determined before git metadata is left behind by copying the project
+	if options, err = resolveProject(options, "", log); err != nil {
+		return nil, err
+	}
+	options, err = warmOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*WarmResult
+	for _, node := range nodes {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		result := &WarmResult{Node: node}
+		results = append(results, result)
+		result.Err = warm(ctx, options, projectDir, result, log.With("node", node))
+		if result.Err != nil {
+			log.Error("Warming failed", "node", node, "error", result.Err)
+		}
+	}
+
+	log.Trace("complete")
+	return results, nil
+}
+
+// warmOptions returns a copy of options usable from a temporary copy of the project
+func warmOptions(options *Options) (*Options, error) {
+	copied := *options
+	// The report and metrics describe a single installation
+	copied.ReportFile = ""
+	copied.MetricsFile = ""
+
+	var err error
+	if copied.TempDir != "" {
+		if copied.TempDir, err = filepath.Abs(copied.TempDir); err != nil {
+			return nil, err
+		}
+	}
+	if options.LocalCache != nil {
+		localCache := *options.LocalCache
+		if localCache.Dir != "" {
+			if localCache.Dir, err = filepath.Abs(localCache.Dir); err != nil {
+				return nil, err
+			}
+		}
+		copied.LocalCache = &localCache
+	}
+	return &copied, nil
+}
+
+// warm installs and caches the packages of the project using one Node.js binary unless already cached
+func warm(ctx context.Context, options *Options, projectDir string, result *WarmResult, log hclog.Logger) error {
+	nodeBinary, npmBinary, err := resolveNodeBinaries(result.Node)
+	if err != nil {
+		return err
+	}
+	result.NodeBinary = nodeBinary
+
+	builder := newConfigBuilder(options)
+	builder.WithNodeBinary(nodeBinary)
+	builder.WithNpmBinary(npmBinary)
+	builder.WithProjectDir(projectDir)
+	// npm runs using the node binary found first in PATH
+	builder.WithEnv("PATH=" + filepath.Dir(nodeBinary) + string(os.PathListSeparator) + os.Getenv("PATH"))
+	config, err := builder.Build(ctx)
+	if err != nil {
+		return err
+	}
+	m, err := NewWithConfig(options, config, log)
+	if err != nil {
+		return err
+	}
+
+	if result.CacheKey, err = m.createCacheKey(); err != nil {
+		return err
+	}
+	for _, cache := range m.caches {
+		found, err := cache.Has(ctx, result.CacheKey)
+		if err != nil {
+			return fmt.Errorf("%s: %v", cache, err)
+		}
+		if found {
+			log.Info("Already cached", "cacheKey", result.CacheKey, "cache", fmt.Sprint(cache))
+			result.Cached = true
+			return nil
+		}
+	}
+
+	log.Info("Not cached, installing", "cacheKey", result.CacheKey, "nodeBinary", nodeBinary)
+	return inProjectCopy(projectDir, options.TempDir, func(copyDir string) error {
+		copyConfig := *config
+		copyConfig.projectDir = copyDir
+		m, err := NewWithConfig(options, &copyConfig, log)
+		if err != nil {
+			return err
+		}
+		return m.Run(ctx)
+	})
+}
+
+// inProjectCopy calls fn with a temporary copy of the project without node_modules
+func inProjectCopy(projectDir string, tempDir string, fn func(copyDir string) error) error {
+	copyDir, err := os.MkdirTemp(tempDir, "npmi-warm-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(copyDir)
+	if rel, err := filepath.Rel(projectDir, copyDir); err == nil && !strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("temporary directory %s is inside the project", copyDir)
+	}
+
+	if err := files.CopyDirectory(projectDir, copyDir, defaultModulesDirectory, ".git"); err != nil {
+		return fmt.Errorf("could not copy project: %v", err)
+	}
+
+	return fn(copyDir)
+}
+
+// resolveNodeBinaries returns the node and npm binaries of a node given as a path
+// to a node binary or as a version installed using a version manager
+func resolveNodeBinaries(node string) (nodeBinary string, npmBinary string, err error) {
+	if strings.ContainsRune(node, filepath.Separator) || strings.ContainsRune(node, '/') {
+		nodeBinary, err = filepath.Abs(node)
+		if err != nil {
+			return "", "", err
+		}
+	} else if nodeBinary, err = findInstalledNode(node); err != nil {
+		return "", "", err
+	}
+
+	npmBinary = filepath.Join(filepath.Dir(nodeBinary), npmBinaryName())
+	if found, err := files.IsExistingFile(npmBinary); err != nil || !found {
+		return "", "", fmt.Errorf("npm not found next to %s", nodeBinary)
+	}
+	return nodeBinary, npmBinary, nil
+}
+
+// npmBinaryName returns the name of the npm executable installed next to the node binary
+func npmBinaryName() string {
+	if runtime.GOOS == "windows" {
+		return "npm.cmd"
+	}
+	return "npm"
+}
+
+// nodeVersionDirs describes where a Node.js version manager installs Node.js versions
+type nodeVersionDirs struct {
+	// versionsDir contains a directory per installed version
+	versionsDir string
+	// binary is the path of the node binary within a version directory
+	binary string
+}
+
+// nodeVersionManagerDirs returns the installation directories of nvm, volta and fnm
+func nodeVersionManagerDirs() []nodeVersionDirs {
+	home, _ := os.UserHomeDir()
+	envOr := func(name string, fallback string) string {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+		return fallback
+	}
+
+	fnmDir := envOr("FNM_DIR", filepath.Join(envOr("XDG_DATA_HOME", filepath.Join(home, ".local", "share")), "fnm"))
+	return []nodeVersionDirs{
+		{filepath.Join(envOr("NVM_DIR", filepath.Join(home, ".nvm")), "versions", "node"), filepath.Join("bin", "node")},
+		{filepath.Join(envOr("VOLTA_HOME", filepath.Join(home, ".volta")), "tools", "image", "node"), filepath.Join("bin", "node")},
+		{filepath.Join(fnmDir, "node-versions"), filepath.Join("installation", "bin", "node")},
+	}
+}
+
+// findInstalledNode finds the node binary of the highest installed version matching
+// version, e.g. 20, 20.10 or v20.10.0
+func findInstalledNode(version string) (string, error) {
+	wanted, err := parseNodeVersion(version)
+	if err != nil {
+		return "", fmt.Errorf("invalid node version or path '%s'", version)
+	}
+
+	var best []int
+	var bestBinary string
+	for _, dirs := range nodeVersionManagerDirs() {
+		entries, err := os.ReadDir(dirs.versionsDir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			installed, err := parseNodeVersion(entry.Name())
+			if err != nil || len(installed) != 3 || !slices.Equal(installed[:len(wanted)], wanted) {
+				continue
+			}
+			binary := filepath.Join(dirs.versionsDir, entry.Name(), dirs.binary)
+			if found, _ := files.IsExistingFile(binary); !found {
+				continue
+			}
+			if best == nil || slices.Compare(installed, best) > 0 {
+				best, bestBinary = installed, binary
+			}
+		}
+	}
+
+	if bestBinary == "" {
+		return "", fmt.Errorf("node %s is not installed using nvm, volta or fnm", version)
+	}
+	return bestBinary, nil
+}
+
+// parseNodeVersion parses a full or partial version such as v20.10.0 or 20
+func parseNodeVersion(version string) ([]int, error) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("invalid version '%s'", version)
+	}
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return nil, fmt.Errorf("invalid version '%s'", version)
+		}
+		numbers[i] = number
+	}
+	return numbers, nil
+}
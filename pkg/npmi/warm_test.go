@@ -0,0 +1,171 @@
+package npmi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestResolveNodeBinaries(t *testing.T) {
+	nvmDir := t.TempDir()
+	t.Setenv("NVM_DIR", nvmDir)
+	t.Setenv("VOLTA_HOME", filepath.Join(nvmDir, "none"))
+	t.Setenv("FNM_DIR", filepath.Join(nvmDir, "none"))
+	for _, version := range []string{"v18.19.0", "v20.9.0", "v20.10.0", "v22.1.0"} {
+		bin := filepath.Join(nvmDir, "versions", "node", version, "bin")
+		if err := os.MkdirAll(bin, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"node", "npm"} {
+			if err := os.WriteFile(filepath.Join(bin, name), nil, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// A version without npm
+	if err := os.MkdirAll(filepath.Join(nvmDir, "versions", "node", "v16.20.0", "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(nvmDir, "versions", "node", "v16.20.0", "bin", "node"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		node    string
+		want    string
+		wantErr bool
+	}{
+		{"20", "v20.10.0", false},
+		{"v20.9", "v20.9.0", false},
+		{"18.19.0", "v18.19.0", false},
+		{"22", "v22.1.0", false},
+		{"21", "", true},
+		{"16", "", true},
+		{"lts", "", true},
+		{filepath.Join(nvmDir, "versions", "node", "v18.19.0", "bin", "node"), "v18.19.0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.node, func(t *testing.T) {
+			nodeBinary, npmBinary, err := resolveNodeBinaries(tt.node)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("resolveNodeBinaries() = %s, was expecting an error", nodeBinary)
+				}
+				return
+			}
+			bin := filepath.Join(nvmDir, "versions", "node", tt.want, "bin")
+			if err != nil || nodeBinary != filepath.Join(bin, "node") || npmBinary != filepath.Join(bin, "npm") {
+				t.Errorf("resolveNodeBinaries() = %s, %s, %v, want node %s", nodeBinary, npmBinary, err, tt.want)
+			}
+		})
+	}
+}
+
+// writeFakeNode creates node and npm scripts in dir. node prints the given platform
+// and npm ci installs a package, logging the directory and PATH it was run with.
+func writeFakeNode(t *testing.T, dir string, platform string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	node := "#!/bin/sh\necho " + platform + "\n"
+	npm := `#!/bin/sh
+case "$1" in
+ci)
+	echo "$(pwd) $PATH" >> "$NPMI_TEST_NPM_LOG"
+	mkdir -p node_modules/dep && echo hello > node_modules/dep/index.js
+	;;
+--version)
+	echo 10.0.0
+	;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "node"), []byte(node), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "npm"), []byte(npm), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "node")
+}
+
+func TestWarm(t *testing.T) {
+	bin := t.TempDir()
+	node18 := writeFakeNode(t, filepath.Join(bin, "18"), "v18.19.0-linux-x64-abi108")
+	node20 := writeFakeNode(t, filepath.Join(bin, "20"), "v20.10.0-linux-x64-abi115")
+	npmLog := filepath.Join(t.TempDir(), "npm.log")
+	t.Setenv("NPMI_TEST_NPM_LOG", npmLog)
+
+	projectDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(projectDir, "package-lock.json"), []byte(`{"lockfileVersion": 3}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	options := DefaultOptions()
+	options.Mode = ModeDevelopment
+	options.TempDir = t.TempDir()
+	options.LocalCache.Dir = t.TempDir()
+	path := os.Getenv("PATH")
+
+	if _, err := Warm(context.Background(), options, []string{node18}, hclog.NewNullLogger()); err != nil {
+		t.Fatal(err)
+	}
+	results, err := Warm(context.Background(), options, []string{node18, node20}, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Err != nil || results[1].Err != nil {
+		t.Fatalf("Unexpected results %+v", results)
+	}
+	if !results[0].Cached || results[1].Cached {
+		t.Errorf("Was expecting node 18 to be cached and node 20 to be installed, got %+v, %+v", results[0], results[1])
+	}
+
+	// Each missing key was installed once in a temporary copy with its node first in PATH
+	data, err := os.ReadFile(npmLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Was expecting npm ci to run twice, got %q", lines)
+	}
+	for i, nodeDir := range []string{filepath.Dir(node18), filepath.Dir(node20)} {
+		dir, runPath, _ := strings.Cut(lines[i], " ")
+		if !strings.HasPrefix(dir, options.TempDir) {
+			t.Errorf("npm ci ran in %s, want a copy in %s", dir, options.TempDir)
+		}
+		if !strings.HasPrefix(runPath, nodeDir+string(os.PathListSeparator)) {
+			t.Errorf("npm ci ran with PATH %s, want %s first", runPath, nodeDir)
+		}
+	}
+	for _, result := range results {
+		found, err := os.Stat(filepath.Join(options.LocalCache.Dir, result.CacheKey))
+		if err != nil || found.Size() == 0 {
+			t.Errorf("Was expecting %s to be cached, got %v", result.CacheKey, err)
+		}
+	}
+
+	// The process and the project are left unchanged
+	if cwd, _ := os.Getwd(); cwd != projectDir {
+		t.Errorf("Current directory changed to %s", cwd)
+	}
+	if os.Getenv("PATH") != path {
+		t.Errorf("PATH changed to %s", os.Getenv("PATH"))
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, "node_modules")); !os.IsNotExist(err) {
+		t.Errorf("Was not expecting node_modules in the project, got %v", err)
+	}
+}